@@ -0,0 +1,327 @@
+package dbcache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbmonitor"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+	"reflect"
+	"time"
+)
+
+// TableConfig selects which rows and columns of table are cached.
+type TableConfig struct {
+	Columns []string          `json:"columns"`          // nil selects all columns of schema
+	Select  *dbmonitor.Select `json:"select,omitempty"` // nil selects all kinds of changes
+	// Where conditions select cached rows, nil selects all rows. Conditions
+	// need "monitor_cond" or "monitor_cond_since" method, which is used if
+	// cache Method is not set.
+	Where [][]interface{} `json:"where,omitempty"`
+}
+
+// completeConfig checks tables and columns against schema and fills in
+// defaults, so config is compared and saved as server sees it.
+func completeConfig(tables map[string]TableConfig, schemaDef *ovshelper.Schema) (map[string]TableConfig, error) {
+	config := make(map[string]TableConfig, len(tables))
+	for table, c := range tables {
+		def, ok := schemaDef.Tables[table]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("table %s is not in schema %s", table, schemaDef.Name))
+		}
+		if c.Columns == nil {
+			c.Columns = ovshelper.SortedKeys(def.Columns)
+		} else {
+			for _, column := range c.Columns {
+				if _, ok := def.Columns[column]; !ok {
+					return nil, errors.New(fmt.Sprintf("column %s is not in table %s", column, table))
+				}
+			}
+		}
+		if c.Select == nil {
+			c.Select = &dbmonitor.Select{Initial: true, Insert: true, Delete: true, Modify: true}
+		}
+		config[table] = c
+	}
+	return config, nil
+}
+
+func columnsOf(config map[string]TableConfig) map[string][]string {
+	columns := make(map[string][]string, len(config))
+	for table, c := range config {
+		columns[table] = c.Columns
+	}
+	return columns
+}
+
+func hasConditions(config map[string]TableConfig) bool {
+	for _, c := range config {
+		if c.Where != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// sameConditions compares conditions in JSON form, as conditions loaded from
+// file have other Go types than given by caller.
+func sameConditions(a [][]interface{}, b [][]interface{}) bool {
+	if (a == nil) != (b == nil) {
+		return false
+	}
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(encodedA) == string(encodedB)
+}
+
+// sameMonitor tells whether configs select the same tables, columns and
+// changes, so they only differ in conditions.
+func sameMonitor(a map[string]TableConfig, b map[string]TableConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for table, c := range a {
+		other, ok := b[table]
+		if !ok || !sameColumns(c.Columns, other.Columns) || !reflect.DeepEqual(c.Select, other.Select) {
+			return false
+		}
+	}
+	return true
+}
+
+// monitorMethod returns method used for config, conditions need one of
+// conditional methods.
+func (cache *Cache) monitorMethod(config map[string]TableConfig) (string, error) {
+	switch cache.Method {
+	case "":
+		if hasConditions(config) {
+			return "monitor_cond", nil
+		}
+		return "monitor", nil
+	case "monitor":
+		if hasConditions(config) {
+			return "", errors.New("conditions need monitor_cond or monitor_cond_since method")
+		}
+		return cache.Method, nil
+	case "monitor_cond", "monitor_cond_since":
+		return cache.Method, nil
+	}
+	return "", errors.New("unknown monitor method: " + cache.Method)
+}
+
+// startMonitor starts monitor for config. Notifications are applied if
+// monitor is current cache monitor and cache is synced, otherwise they wait
+// in pending until initial rows are applied. Monitor replacing running one is
+// started as next, so notifications of current monitor are applied until
+// initial rows of next are.
+func (cache *Cache) startMonitor(schema string, config map[string]TableConfig, method string, since string, next bool) (*dbmonitor.Monitor, json.RawMessage, error) {
+	monitor := cache.OVSDB.Monitor(schema)
+	for table, c := range config {
+		monitor.Register(table, dbmonitor.Table{
+			Columns: c.Columns,
+			Where:   c.Where,
+			Select:  *c.Select,
+		})
+	}
+
+	callback := func(response json.RawMessage) {
+		cache.Lock()
+		defer cache.Unlock()
+		switch {
+		case monitor == cache.monitor && cache.synced:
+			cache.notification(response)
+		case monitor == cache.monitor || monitor == cache.next:
+			cache.pending = append(cache.pending, response)
+		}
+	}
+
+	// callback must know monitor before first notification
+	cache.Lock()
+	if next {
+		cache.next = monitor
+	} else {
+		cache.monitor = monitor
+		cache.synced = false
+		cache.next = nil
+	}
+	cache.pending = nil
+	cache.Unlock()
+
+	var res json.RawMessage
+	var err error
+	switch method {
+	case "monitor":
+		res, err = monitor.Start(callback)
+	case "monitor_cond":
+		res, err = monitor.StartConditional(callback)
+	case "monitor_cond_since":
+		res, err = monitor.StartConditionalSince(since, callback)
+	}
+	return monitor, res, err
+}
+
+// initialRows decodes initial monitor reply to update format. Found tells
+// whether monitor_cond_since server sent only changes after since.
+func (cache *Cache) initialRows(res json.RawMessage) (map[string]map[string]dbmonitor.RowUpdate, bool, error) {
+	switch cache.Method {
+	case "", "monitor":
+		var update map[string]map[string]dbmonitor.RowUpdate
+		err := decodeRaw(res, &update)
+		return update, false, err
+	case "monitor_cond":
+		update, err := cache.convertUpdate2(res)
+		return update, false, err
+	}
+
+	var reply []json.RawMessage
+	if err := json.Unmarshal(res, &reply); err != nil {
+		return nil, false, err
+	}
+	if len(reply) != 3 {
+		return nil, false, errors.New("malformed monitor_cond_since reply")
+	}
+	var found bool
+	json.Unmarshal(reply[0], &found)
+	json.Unmarshal(reply[1], &cache.lastTxnId)
+	// changes after since are applied to cached rows, so they are decoded
+	// by caller
+	if found {
+		return nil, true, nil
+	}
+	update, err := cache.convertUpdate2(reply[2])
+	return update, false, err
+}
+
+// Reconfigure changes cached tables, columns or conditions of started cache.
+// If only conditions change and cache uses conditional monitor, server is
+// asked to change them and sends rows which start or stop matching. Otherwise
+// new monitor replaces the old one and cached rows are replaced with its
+// initial rows. In both cases event handlers see only rows which changed,
+// and indexes of tables which stay are kept.
+func (cache *Cache) Reconfigure(tables map[string]TableConfig) error {
+	cache.RLock()
+	monitor := cache.monitor
+	current := cache.config
+	schemaDef := cache.schemaDef
+	ready := cache.ready
+	cache.RUnlock()
+
+	if monitor == nil || !ready {
+		return errors.New("cache is not monitored")
+	}
+	config, err := completeConfig(tables, schemaDef)
+	if err != nil {
+		return err
+	}
+	method, err := cache.monitorMethod(config)
+	if err != nil {
+		return err
+	}
+	if method != cache.Method && cache.Method != "" {
+		return errors.New("monitor method can't be changed")
+	}
+
+	if method != "monitor" && method == cache.Method && sameMonitor(current, config) {
+		changes := map[string][][]interface{}{}
+		for table, c := range config {
+			if !sameConditions(c.Where, current[table].Where) {
+				changes[table] = c.Where
+			}
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+		// rows starting or stopping to match are published with new conditions
+		cache.Lock()
+		cache.config = config
+		cache.Unlock()
+		if _, err := monitor.ChangeConditions(changes); err != nil {
+			cache.Lock()
+			cache.config = current
+			cache.Unlock()
+			return err
+		}
+		return nil
+	}
+
+	next, res, err := cache.startMonitor(cache.Schema, config, method, "", true)
+
+	cache.Lock()
+	if err != nil || cache.next != next {
+		if cache.next == next {
+			cache.next = nil
+			cache.pending = nil
+		}
+		cache.Unlock()
+		if err == nil {
+			return errors.New("cache was restarted during reconfiguration")
+		}
+		return err
+	}
+
+	cache.monitor = next
+	cache.next = nil
+	cache.Method = method
+	err = cache.resync(config, res)
+	for _, response := range cache.pending {
+		cache.notification(response)
+	}
+	cache.pending = nil
+	cache.lastUpdate = time.Now()
+	cache.flushEvents()
+	cache.signal()
+	cache.Unlock()
+
+	go cache.watchMonitor(next)
+	monitor.Cancel()
+	return err
+}
+
+// resync replaces cached rows with initial rows of new monitor. Event
+// handlers see differences between old and new rows. Must be called with
+// cache locked.
+func (cache *Cache) resync(config map[string]TableConfig, res json.RawMessage) error {
+	old := cache.rows
+	// defaults of update2 rows are filled for new columns
+	cache.monitored = columnsOf(config)
+	cache.config = config
+
+	update, _, err := cache.initialRows(res)
+	if err != nil {
+		return err
+	}
+	rows := make(map[string]map[string]map[string]interface{}, len(update))
+	for table, tableUpdate := range update {
+		rows[table] = make(map[string]map[string]interface{}, len(tableUpdate))
+		for uuid, rowUpdate := range tableUpdate {
+			rows[table][uuid] = rowUpdate.New
+		}
+	}
+	if err := cache.restore(cache.monitored, cache.schemaDef, rows, false); err != nil {
+		return err
+	}
+
+	tables := map[string]bool{}
+	for table := range old {
+		tables[table] = true
+	}
+	for table := range cache.rows {
+		tables[table] = true
+	}
+	for _, table := range ovshelper.SortedKeys(tables) {
+		uuids := map[string]bool{}
+		for uuid := range old[table] {
+			uuids[uuid] = true
+		}
+		for uuid := range cache.rows[table] {
+			uuids[uuid] = true
+		}
+		for _, uuid := range ovshelper.SortedKeys(uuids) {
+			oldRow, newRow := old[table][uuid], cache.rows[table][uuid]
+			if !reflect.DeepEqual(oldRow, newRow) {
+				cache.recordChange(table, uuid, oldRow, newRow)
+			}
+		}
+	}
+	return nil
+}
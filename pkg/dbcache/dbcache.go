@@ -3,9 +3,12 @@ package dbcache
 import (
 	"encoding/json"
 	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbmonitor"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type iOVSDB interface {
@@ -19,41 +22,172 @@ type Cache struct {
 	sync.RWMutex
 	OVSDB iOVSDB
 	Schema string
-	Indexes map[string][]string
+	Method string // monitor method: "monitor" (default), "monitor_cond" or "monitor_cond_since"
+	Indexes map[string][]string // index specifications by table, see Lookup
 	Data map[string]interface{} // Data[table][index_type][index_val][column]
+	rows map[string]map[string]map[string]interface{} // rows[table][uuid][column] in OVSDB notation, row maps are not changed once stored
+	current atomic.Pointer[Snapshot]
+	indexes map[string]map[string]*index // indexes[table][spec], includes indexes declared in schema
+	events *events
+	schemaDef *ovshelper.Schema
+	monitored map[string][]string // monitored columns by table
+	synced bool // initial rows are applied, notifications can be applied
+	pending []json.RawMessage // notifications received before initial rows
+	rebuild bool // next snapshot is built from all rows
+	ready bool
+	generation uint64
+	lastUpdate time.Time
+	lastTxnId string
+	schemaVersion string // version of schema of cached rows, see Load
+	config map[string]TableConfig // completed with defaults, see Start
+	monitor *dbmonitor.Monitor // monitor which updates cache
+	next *dbmonitor.Monitor // monitor replacing current one, see Reconfigure
+	onPublish func(*Snapshot) // called with each new snapshot, see MultiCache
+	changed chan struct{} // closed on each change, see WaitFor
 }
 
+// StartMonitor fills cache with rows of tables and keeps it up to date.
+// Tables map table names to monitored columns, nil means all columns. It can
+// be called again, for example after reconnect, to refill cache.
 func (cache *Cache) StartMonitor(schema string, tables map[string][]string) error {
-	monitor := cache.OVSDB.Monitor(schema)
-
+	config := make(map[string]TableConfig, len(tables))
 	for table, columns := range tables {
-		monitor.Register(table, dbmonitor.Table{
-			Columns: columns,
-			Select: dbmonitor.Select{Initial:true, Insert: true, Delete: true, Modify: true,},
-		})
+		config[table] = TableConfig{Columns: columns}
 	}
+	return cache.Start(schema, config)
+}
 
-	res, err := monitor.Start(func(response json.RawMessage) {
-		cache.Lock()
-		cache.update(response)
-		cache.Unlock()
-	})
+// Start works like StartMonitor, but allows to select rows and changes of
+// tables, see TableConfig. If Method is not set, "monitor_cond" is used
+// when conditions are given.
+func (cache *Cache) Start(schema string, tables map[string]TableConfig) error {
+	schemaDef, err := cache.fetchSchema(schema)
+	if err != nil {
+		return err
+	}
+	config, err := completeConfig(tables, schemaDef)
+	if err != nil {
+		return err
+	}
+	method, err := cache.monitorMethod(config)
 	if err != nil {
 		return err
 	}
 
 	cache.Lock()
-	cache.Data = make(map[string]interface{})
-	err2 := cache.update(res)
-	if err2 != nil {
-		cache.Unlock()
-		return err2
+	var since string
+	if method == "monitor_cond_since" && cache.resumable(config, schemaDef) {
+		since = cache.lastTxnId
 	}
 	cache.Unlock()
 
+	monitor, res, err := cache.startMonitor(schema, config, method, since, false)
+	if err != nil {
+		return err
+	}
+
+	// OVSDB handle may pass notifications with its callbacks locked and
+	// notifications lock cache, so close callback is registered before cache
+	// is locked. Connection closed meanwhile is noticed by watchMonitor.
+	if notifier, ok := cache.OVSDB.(closeNotifier); ok {
+		notifier.AddCloseCallback(cache.callbackId(), cache.disconnected)
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	if cache.monitor != monitor {
+		return errors.New("cache was restarted meanwhile")
+	}
+	if cache.Method == "" && method != "monitor" {
+		cache.Method = method
+	}
+	columns := columnsOf(config)
+	// update2 rows are completed with defaults of monitored columns
+	cache.monitored = columns
+	cache.schemaDef = schemaDef
+	// snapshots carry conditions of config, see Snapshot.Conditions
+	cache.config = config
+	update, found, err := cache.initialRows(res)
+	if err == nil && found && since != "" {
+		// server sent only changes after since, they are applied to cached rows
+		var reply []json.RawMessage
+		json.Unmarshal(res, &reply)
+		if err = cache.restore(columns, schemaDef, cache.rows, false); err == nil {
+			err = cache.update2(reply[2])
+		}
+	} else if err == nil {
+		lastTxnId := cache.lastTxnId
+		cache.reset(columns, schemaDef)
+		if cache.Method == "monitor_cond_since" {
+			cache.lastTxnId = lastTxnId
+		}
+		err = cache.apply(update)
+	}
+	if err != nil {
+		return err
+	}
+
+	cache.synced = true
+	cache.ready = true
+	cache.generation++
+	cache.lastUpdate = time.Now()
+	for _, response := range cache.pending {
+		cache.notification(response)
+	}
+	cache.pending = nil
+	cache.flushEvents()
+	cache.signal()
+
+	go cache.watchMonitor(monitor)
+
 	return nil
 }
 
+// reset empties cache before it is filled with initial rows. Event handlers
+// see deletes of all rows followed by adds of initial rows. Must be called
+// with cache locked.
+func (cache *Cache) reset(tables map[string][]string, schemaDef *ovshelper.Schema) {
+	for table, rows := range cache.rows {
+		for _, uuid := range sortedRowIds(rows) {
+			cache.recordChange(table, uuid, rows[uuid], nil)
+		}
+	}
+
+	cache.Data = make(map[string]interface{})
+	cache.rows = make(map[string]map[string]map[string]interface{})
+	for table, _ := range tables {
+		cache.rows[table] = make(map[string]map[string]interface{})
+		cache.initData(table)
+	}
+	cache.monitored = tables
+	cache.schemaDef = schemaDef
+	cache.makeIndexes(tables, schemaIndexes(schemaDef))
+	cache.rebuild = true
+	cache.lastTxnId = ""
+	cache.schemaVersion = schemaDef.Version
+}
+
+// notification applies update notification of monitor. Must be called with
+// cache locked.
+func (cache *Cache) notification(response json.RawMessage) {
+	switch cache.Method {
+	case "", "monitor":
+		cache.update(response)
+	case "monitor_cond":
+		cache.update2(response)
+	case "monitor_cond_since":
+		var params []json.RawMessage
+		if err := json.Unmarshal(response, &params); err == nil && len(params) == 2 {
+			json.Unmarshal(params[0], &cache.lastTxnId)
+			cache.update2(params[1])
+		}
+	}
+	cache.lastUpdate = time.Now()
+	cache.flushEvents()
+	cache.signal()
+}
+
 func normalize (data interface{}) interface{} {
 	switch data.(type) {
 	case []interface{}:
@@ -72,7 +206,7 @@ func normalize (data interface{}) interface{} {
 					}
 				default: // we have a list of strings, numbers or booleans
 					for _, val := range a[1].([]interface{}) {
-						m[val.(string)] = val
+						m[normalizeKey(val)] = normalize(val)
 					}
 				}
 				return m
@@ -85,7 +219,7 @@ func normalize (data interface{}) interface{} {
 				m := map[string]interface{}{}
 				// we convert list type map to real map, items always are pairs
 				for _, val := range a[1].([]interface{}) {
-					m[val.([]interface{})[0].(string)] = normalize(val)
+					m[normalizeKey(val.([]interface{})[0])] = normalize(val)
 				}
 				return m
 			}
@@ -99,6 +233,9 @@ func normalize (data interface{}) interface{} {
 			// we have a pair, key is stored previously in map
 			return a[1]
 		}
+	case json.Number:
+		f, _ := data.(json.Number).Float64()
+		return f
 	default:
 		return data
 	}
@@ -106,6 +243,15 @@ func normalize (data interface{}) interface{} {
 	return nil // never invoked
 }
 
+// normalizeKey converts set element or map key to string used as Go map key,
+// keys of integer, real and boolean sets are formatted values.
+func normalizeKey(data interface{}) string {
+	if s, ok := data.(string); ok {
+		return s
+	}
+	return fmt.Sprint(normalize(data))
+}
+
 func normalizeMap (data map[string]interface{}) map[string]interface{} {
 	ret := map[string]interface{}{}
 	for key, val := range data {
@@ -116,49 +262,88 @@ func normalizeMap (data map[string]interface{}) map[string]interface{} {
 
 func (cache *Cache) update(response json.RawMessage) error {
 	var update map[string]map[string]dbmonitor.RowUpdate
-	json.Unmarshal(response, &update)
+	decodeRaw(response, &update)
+
+	return cache.apply(update)
+}
+
+func (cache *Cache) update2(response json.RawMessage) error {
+	update, err := cache.convertUpdate2(response)
+	if err != nil {
+		return err
+	}
+
+	return cache.apply(update)
+}
+
+// apply applies update in update format. Must be called with cache locked.
+func (cache *Cache) apply(update map[string]map[string]dbmonitor.RowUpdate) error {
+	changed := map[string]map[string]bool{}
+	defer cache.publish(changed)
 
 	for table, data := range update {
 		for uuid, rowUpdate := range data {
-			// make structures on initial update
+			// structures of monitored tables are made on reset
 			if _, ok := cache.Data[table]; !ok {
-				cache.Data[table] = make(map[string]interface{})
-				cache.Data[table].(map[string]interface{})["uuid"] = make(map[string]interface{})
+				cache.initData(table)
+			}
+			if _, ok := cache.rows[table]; !ok {
+				cache.rows[table] = make(map[string]map[string]interface{})
+			}
+
+			tableData := cache.Data[table].(map[string]interface{})
 
-				for _, index := range cache.Indexes[table] {
+			// index columns are checked with first row of table
+			if len(tableData["uuid"].(map[string]interface{})) == 0 && rowUpdate.New != nil {
+				for _, index := range cache.legacyIndexes(table) {
 					if _, ok := rowUpdate.New[index]; !ok { // for initial update there will be "New"
 						return errors.New(fmt.Sprintf("wrong index (%s) provided for table: %s", index, table))
 					}
-
-					cache.Data[table].(map[string]interface{})[index] = make(map[string]interface{})
 				}
 			}
 
+			// legacy index entries of row before change
+			oldValues := cache.legacyValues(table, uuid)
+
+			oldRow := cache.rows[table][uuid]
+
 			// update cache depending on activity type
 			if rowUpdate.New != nil && rowUpdate.Old == nil { // initial or insert
-				cache.Data[table].(map[string]interface{})["uuid"].(map[string]interface{})[uuid] = normalizeMap(rowUpdate.New)
-				cache.Data[table].(map[string]interface{})["uuid"].(map[string]interface{})[uuid].(map[string]interface{})["uuid"] = uuid
+				cache.rows[table][uuid] = rowUpdate.New
 
-				for _, index := range cache.Indexes[table] {
-					indexValue := cache.Data[table].(map[string]interface{})["uuid"].(map[string]interface{})[uuid].(map[string]interface{})[index].(string)
-					cache.Data[table].(map[string]interface{})[index].(map[string]interface{})[indexValue] = cache.Data[table].(map[string]interface{})["uuid"].(map[string]interface{})[uuid]
-				}
+				tableData["uuid"].(map[string]interface{})[uuid] = normalizeMap(rowUpdate.New)
+				tableData["uuid"].(map[string]interface{})[uuid].(map[string]interface{})["uuid"] = uuid
 			} else if rowUpdate.Old != nil && rowUpdate.New == nil { // delete
-				// remove from custom index
-				for _, index := range cache.Indexes[table] {
-					indexValue := cache.Data[table].(map[string]interface{})["uuid"].(map[string]interface{})[uuid].(map[string]interface{})[index].(string)
-					delete(cache.Data[table].(map[string]interface{})[index].(map[string]interface{}), indexValue)
-				}
-
-				delete(cache.Data[table].(map[string]interface{})["uuid"].(map[string]interface{}), uuid)
+				delete(tableData["uuid"].(map[string]interface{}), uuid)
+				delete(cache.rows[table], uuid)
 			} else { // modify
+				// snapshots share row maps, so changed row is a new map
+				row := copyRow(cache.rows[table][uuid])
+				cache.rows[table][uuid] = row
 				for column, _ := range rowUpdate.Old { // old contains only changed
-					cache.Data[table].(map[string]interface{})["uuid"].(map[string]interface{})[uuid].(map[string]interface{})[column] = normalize(rowUpdate.New[column])
+					row[column] = rowUpdate.New[column]
+					// index entries refer to the same map, so they see the change
+					tableData["uuid"].(map[string]interface{})[uuid].(map[string]interface{})[column] = normalize(rowUpdate.New[column])
+				}
+			}
 
-					for _, index := range cache.Indexes[table] {
-						indexValue := cache.Data[table].(map[string]interface{})["uuid"].(map[string]interface{})[uuid].(map[string]interface{})[index].(string)
-						cache.Data[table].(map[string]interface{})[index].(map[string]interface{})[indexValue].(map[string]interface{})[column] = cache.Data[table].(map[string]interface{})["uuid"].(map[string]interface{})[uuid].(map[string]interface{})[column]
-					}
+			cache.indexRow(table, uuid, cache.rows[table][uuid])
+
+			if changed[table] == nil {
+				changed[table] = map[string]bool{}
+			}
+			changed[table][uuid] = true
+			cache.recordChange(table, uuid, oldRow, cache.rows[table][uuid])
+
+			// index values of row may have changed, so entries for both old
+			// and new values are rebuilt from index
+			newValues := cache.legacyValues(table, uuid)
+			for index, value := range oldValues {
+				cache.syncLegacyIndex(table, index, value)
+			}
+			for index, value := range newValues {
+				if value != oldValues[index] {
+					cache.syncLegacyIndex(table, index, value)
 				}
 			}
 		}
@@ -169,10 +354,64 @@ func (cache *Cache) update(response json.RawMessage) error {
 	return nil
 }
 
-func (cache *Cache) getData(args ...string) interface{} {
+// legacyValues returns values of row in single column indexes. Only string
+// values are kept in Data, rows with other or empty values are skipped.
+func (cache *Cache) legacyValues(table string, uuid string) map[string]string {
+	values := map[string]string{}
+	row, _ := getData(cache.Data, table, "uuid", uuid).(map[string]interface{})
+	for _, index := range cache.legacyIndexes(table) {
+		if value, ok := row[index].(string); ok {
+			values[index] = value
+		}
+	}
+	return values
+}
+
+// syncLegacyIndex points Data[table][index][value] to the row having the
+// value. If there are several such rows, row with the lowest uuid is used and
+// the conflict is reported by Conflicts.
+func (cache *Cache) syncLegacyIndex(table string, index string, value string) {
+	idx, ok := cache.indexes[table][index]
+	if !ok {
+		return
+	}
+	indexData := cache.Data[table].(map[string]interface{})[index].(map[string]interface{})
+	key, err := valuesKey(idx.columns, idx.parts, []interface{}{value})
+	if err != nil {
+		return
+	}
+	uuids := idx.lookup(key)
+	if len(uuids) == 0 {
+		delete(indexData, value)
+		return
+	}
+	indexData[value] = cache.Data[table].(map[string]interface{})["uuid"].(map[string]interface{})[uuids[0]]
+}
+
+// initData makes Data structure of empty table.
+func (cache *Cache) initData(table string) {
+	tableData := map[string]interface{}{"uuid": map[string]interface{}{}}
+	for _, index := range cache.legacyIndexes(table) {
+		tableData[index] = map[string]interface{}{}
+	}
+	cache.Data[table] = tableData
+}
+
+// legacyIndexes returns single column indexes, which are kept in Data.
+func (cache *Cache) legacyIndexes(table string) []string {
+	var indexes []string
+	for _, index := range cache.Indexes[table] {
+		if isLegacyIndex(index) {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+func getData(data map[string]interface{}, args ...string) interface{} {
 	var ret interface{}
-	ret = cache.Data
-	if ret == nil {
+	ret = data
+	if data == nil {
 		return map[string]interface{}{}
 	}
 	for _, val := range args {
@@ -184,20 +423,40 @@ func (cache *Cache) getData(args ...string) interface{} {
 	return ret
 }
 
-func (cache *Cache) GetKeys(args ...string) []string {
-	cache.RLock()
-	data := cache.getData(args...).(map[string]interface{})
-	keys := make([]string, len(data))
+func getKeys(data map[string]interface{}, args ...string) []string {
+	d := getData(data, args...).(map[string]interface{})
+	keys := make([]string, len(d))
 	c := 0
-	for key, _ := range data {
+	for key, _ := range d {
 		keys[c] = key
 		c++
 	}
-	cache.RUnlock()
-
 	return keys
 }
 
+func getList(data map[string]interface{}, args ...string) []interface{} {
+	d := getData(data, args...).(map[string]interface{})
+	list := make([]interface{}, len(d))
+	c := 0
+	for _, val := range d {
+		list[c] = deepCopy(val)
+		c++
+	}
+	return list
+}
+
+func getMap(data map[string]interface{}, args ...string) map[string]interface{} {
+	m := make(map[string]interface{})
+	for key, val := range getData(data, args...).(map[string]interface{}) {
+		m[key] = deepCopy(val)
+	}
+	return m
+}
+
+func (cache *Cache) GetKeys(args ...string) []string {
+	return cache.Snapshot().GetKeys(args...)
+}
+
 func deepCopy(data interface{}) interface{} {
 	switch data.(type) {
 	case map[string]interface{}:
@@ -211,7 +470,8 @@ func deepCopy(data interface{}) interface{} {
 	}
 }
 
-// GetList is used to retrieve data from cache data structure and deep copy it.
+// GetList is used to retrieve data from current cache snapshot without locking
+// cache, returned data is a copy.
 // Cache structure: Data[table][index_type][index_val][column]
 //
 // Arguments is used as keys in order as in structure:
@@ -222,21 +482,11 @@ func deepCopy(data interface{}) interface{} {
 //
 // Any amount of arguments can be provided
 func (cache *Cache) GetList(args ...string) []interface{} {
-	cache.RLock()
-	data := cache.getData(args...)
-	d := data.(map[string]interface{})
-	list := make([]interface{}, len(d))
-	c := 0
-	for _, val := range d {
-		list[c] = deepCopy(val)
-		c++
-	}
-	cache.RUnlock()
-
-	return list
+	return cache.Snapshot().GetList(args...)
 }
 
-// GetMap is used to retrieve data from cache data structure and deep copy it.
+// GetMap is used to retrieve data from current cache snapshot without locking
+// cache, returned data is a copy.
 // Cache structure: Data[table][index_type][index_val][column]
 //
 // Arguments is used as keys in order as in structure:
@@ -247,15 +497,7 @@ func (cache *Cache) GetList(args ...string) []interface{} {
 //
 // Any amount of arguments can be provided
 func (cache *Cache) GetMap(args ...string) map[string]interface{} {
-	cache.RLock()
-	data := cache.getData(args...)
-	m := make(map[string]interface{})
-	for key, val := range data.(map[string]interface{}) {
-		m[key] = deepCopy(val)
-	}
-	cache.RUnlock()
-
-	return m
+	return cache.Snapshot().GetMap(args...)
 }
 
 //func (cache *Cache) Get(args ...string) interface{} {
@@ -0,0 +1,1118 @@
+package dbcache_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbcache"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbtransaction"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/internal/ovsdbtest"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCache_Table(t *testing.T) {
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method == "monitor" {
+			return json.RawMessage(ovsdbtest.InitialUpdate), nil
+		}
+		return nil, nil
+	})
+	cache := ovsdbtest.NewCache(t, f)
+	bridges := dbcache.NewTable[ovsdbtest.Bridge](cache, "Bridge")
+
+	br, err := bridges.Lookup("name", "br0")
+	if err != nil || br == nil {
+		t.Fatal("Bridge not found by index", err)
+	}
+	if br.UUID != ovsdbtest.BridgeId || br.ExternalIds["owner"] != "test" || br.Ports == nil || len(br.Ports) != 0 || br.FailMode != nil {
+		t.Error("Wrong bridge decoded:", br)
+	}
+
+	f.Update(`{"Bridge": {"` + ovsdbtest.BridgeId + `": {
+		"old": {"ports": ["set", []], "fail_mode": ["set", []]},
+		"new": {"name": "br0", "ports": ["uuid", "` + ovsdbtest.RootId + `"], "fail_mode": "secure", "external_ids": ["map", [["owner", "test"]]]}}}}`)
+
+	br, _ = bridges.Get(ovsdbtest.BridgeId)
+	if br == nil || len(br.Ports) != 1 || br.Ports[0] != ovsdbtest.RootId || br.FailMode == nil || *br.FailMode != "secure" {
+		t.Error("Update not decoded:", br)
+	}
+
+	list, _ := bridges.Where(func(b *ovsdbtest.Bridge) bool { return b.ExternalIds["owner"] == "test" })
+	if len(list) != 1 {
+		t.Error("Where did not match")
+	}
+	if br, _ := bridges.Lookup("fail_mode", "secure"); br == nil {
+		t.Error("Lookup without index failed")
+	}
+	if br, _ := bridges.Get(ovsdbtest.RootId); br != nil {
+		t.Error("Missing row returned")
+	}
+
+	roots := dbcache.NewTable[struct {
+		NextCfg string `ovsdb:"next_cfg"`
+	}](cache, "Open_vSwitch")
+	if _, err := roots.List(); err == nil {
+		t.Error("Type mismatch not reported")
+	}
+}
+
+func TestCache_Lookup(t *testing.T) {
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method == "monitor" {
+			return json.RawMessage(ovsdbtest.InitialUpdate), nil
+		}
+		return nil, nil
+	})
+	cache := &dbcache.Cache{
+		OVSDB:  f,
+		Schema: "Open_vSwitch",
+		Indexes: map[string][]string{
+			"Open_vSwitch": {"next_cfg"},
+			"Bridge":       {"external_ids:owner", "name,external_ids:owner"},
+		},
+	}
+	err := cache.StartMonitor("Open_vSwitch", map[string][]string{
+		"Open_vSwitch": nil,
+		"Bridge":       nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name string, expected []string, table string, index string, values ...interface{}) {
+		uuids, err := cache.Lookup(table, index, values...)
+		if err != nil || strings.Join(uuids, " ") != strings.Join(expected, " ") {
+			t.Errorf("%s: got %v %v, expected %v", name, uuids, err, expected)
+		}
+	}
+
+	check("integer index", []string{ovsdbtest.RootId}, "Open_vSwitch", "next_cfg", 3)
+	check("map key index", []string{ovsdbtest.BridgeId}, "Bridge", "external_ids:owner", "test")
+	check("composite index", []string{ovsdbtest.BridgeId}, "Bridge", "name,external_ids:owner", "br0", "test")
+	check("schema index", []string{ovsdbtest.BridgeId}, "Bridge", "name", "br0")
+	check("empty value", nil, "Bridge", "ports", ovshelper.Set{})
+	check("scan", []string{ovsdbtest.BridgeId}, "Bridge", "external_ids", ovshelper.Map{"owner": "test"})
+
+	f.Update(`{
+		"Open_vSwitch": {"` + ovsdbtest.RootId + `": {"old": {"next_cfg": 3}, "new": {"next_cfg": 4, "bridges": ["uuid", "` + ovsdbtest.BridgeId + `"]}}},
+		"Bridge": {"` + ovsdbtest.RootId + `": {"new": {"name": "br1", "ports": ["set", []], "external_ids": ["map", [["owner", "test"]]]}}}
+	}`)
+
+	check("modified index", nil, "Open_vSwitch", "next_cfg", 3)
+	check("modified index", []string{ovsdbtest.RootId}, "Open_vSwitch", "next_cfg", 4)
+	check("non-unique index", []string{ovsdbtest.RootId, ovsdbtest.BridgeId}, "Bridge", "external_ids:owner", "test")
+
+	f.Update(`{"Bridge": {"` + ovsdbtest.BridgeId + `": {"old": {"name": "br0"}}}}`)
+	check("deleted row", []string{ovsdbtest.RootId}, "Bridge", "external_ids:owner", "test")
+
+	if _, err := cache.Lookup("Bridge", "name,external_ids:owner", "br1"); err == nil {
+		t.Error("Wrong value count not reported")
+	}
+}
+
+func TestCache_Lookup_ColumnTypes(t *testing.T) {
+	qosId := "2b3c4d5e-6f70-4a8b-9c0d-1e2f3a4b5c6d"
+	queueId := "3c4d5e6f-7081-4b9c-8d0e-2f3a4b5c6d7e"
+	otherRootId := "4d5e6f70-8192-4cad-9e0f-3a4b5c6d7e8f"
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method == "monitor" {
+			return json.RawMessage(`{
+				"Open_vSwitch": {
+					"` + ovsdbtest.RootId + `": {"new": {"next_cfg": 9007199254740993, "bridges": ["uuid", "` + ovsdbtest.BridgeId + `"]}},
+					"` + otherRootId + `": {"new": {"next_cfg": 9007199254740992, "bridges": ["set", []]}}
+				},
+				"QoS": {"` + qosId + `": {"new": {"queues": ["map", [[0, ["uuid", "` + queueId + `"]]]]}}}
+			}`), nil
+		}
+		return nil, nil
+	})
+	cache := &dbcache.Cache{
+		OVSDB:  f,
+		Schema: "Open_vSwitch",
+		Indexes: map[string][]string{
+			"Open_vSwitch": {"next_cfg"},
+			"QoS":          {"queues:0"},
+		},
+	}
+	err := cache.StartMonitor("Open_vSwitch", map[string][]string{
+		"Open_vSwitch": nil,
+		"QoS":          nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name string, expected []string, table string, index string, values ...interface{}) {
+		uuids, err := cache.Lookup(table, index, values...)
+		if err != nil || strings.Join(uuids, " ") != strings.Join(expected, " ") {
+			t.Errorf("%s: got %v %v, expected %v", name, uuids, err, expected)
+		}
+	}
+
+	check("integer map key", []string{qosId}, "QoS", "queues:0", queueId)
+	check("integer map key scan", nil, "QoS", "queues:1", queueId)
+	check("uuid by string", []string{ovsdbtest.RootId}, "Open_vSwitch", "bridges", ovsdbtest.BridgeId)
+	check("uuid by UUID", []string{ovsdbtest.RootId}, "Open_vSwitch", "bridges", ovshelper.UUID(ovsdbtest.BridgeId))
+	check("large integer", []string{ovsdbtest.RootId}, "Open_vSwitch", "next_cfg", int64(9007199254740993))
+	check("large integer", []string{otherRootId}, "Open_vSwitch", "next_cfg", int64(9007199254740992))
+	check("integral real", []string{otherRootId}, "Open_vSwitch", "next_cfg", float64(9007199254740992))
+}
+
+func TestCache_IndexConflicts(t *testing.T) {
+	const otherId = "2b3c4d5e-6f7a-4b8c-9d0e-1f2a3b4c5d6e"
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method == "monitor" {
+			return json.RawMessage(`{"Bridge": {
+				"` + ovsdbtest.BridgeId + `": {"new": {"name": "br0", "fail_mode": ["set", []]}},
+				"` + otherId + `": {"new": {"name": "br0", "fail_mode": "secure"}}
+			}}`), nil
+		}
+		return nil, nil
+	})
+	cache := &dbcache.Cache{
+		OVSDB:   f,
+		Schema:  "Open_vSwitch",
+		Indexes: map[string][]string{"Bridge": {"name", "fail_mode"}},
+	}
+	if err := cache.StartMonitor("Open_vSwitch", map[string][]string{"Bridge": nil}); err != nil {
+		t.Fatal(err)
+	}
+
+	conflicts := cache.Conflicts()
+	if len(conflicts) != 1 || conflicts[0].Index != "name" || len(conflicts[0].UUIDs) != 2 {
+		t.Fatal("Conflict not reported:", conflicts)
+	}
+	if cache.GetMap("Bridge", "name", "br0")["uuid"] != ovsdbtest.BridgeId {
+		t.Error("Conflicting value does not refer to lowest uuid")
+	}
+	if len(cache.GetKeys("Bridge", "fail_mode")) != 1 {
+		t.Error("Unset index value indexed")
+	}
+
+	// deleting one row keeps the other in index
+	f.Update(`{"Bridge": {"` + ovsdbtest.BridgeId + `": {"old": {"name": "br0"}}}}`)
+	if cache.GetMap("Bridge", "name", "br0")["uuid"] != otherId || len(cache.Conflicts()) != 0 {
+		t.Error("Remaining row lost from index")
+	}
+
+	// renamed row moves in index
+	f.Update(`{"Bridge": {"` + otherId + `": {"old": {"name": "br0", "fail_mode": "secure"}, "new": {"name": "br1", "fail_mode": ["set", []]}}}}`)
+	if len(cache.GetKeys("Bridge", "name", "br0")) != 0 || cache.GetMap("Bridge", "name", "br1")["uuid"] != otherId {
+		t.Error("Index not rebuilt on modify")
+	}
+	if len(cache.GetKeys("Bridge", "fail_mode")) != 0 {
+		t.Error("Unset index value kept")
+	}
+}
+
+func TestCache_EventHandler(t *testing.T) {
+	const otherId = "2b3c4d5e-6f7a-4b8c-9d0e-1f2a3b4c5d6e"
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method == "monitor" {
+			return json.RawMessage(ovsdbtest.InitialUpdate), nil
+		}
+		return nil, nil
+	})
+	cache := ovsdbtest.NewCache(t, f)
+
+	events := make(chan string, 10)
+	cache.AddEventHandler(dbcache.EventHandler{
+		Table: "Bridge",
+		Filter: func(row map[string]interface{}) bool {
+			return row["name"] != "ignored"
+		},
+		OnAdd: func(s *dbcache.Snapshot, uuid string, row map[string]interface{}) {
+			events <- "add " + row["name"].(string)
+		},
+		OnUpdate: func(s *dbcache.Snapshot, uuid string, old map[string]interface{}, new map[string]interface{}) {
+			// snapshot matches the event, even if cache changed meanwhile
+			events <- "update " + old["name"].(string) + " " + new["name"].(string) + " " + s.Row("Bridge", uuid)["name"].(string)
+		},
+		OnDelete: func(s *dbcache.Snapshot, uuid string, row map[string]interface{}) {
+			if s.Row("Bridge", uuid) != nil {
+				t.Error("Deleted row in snapshot")
+			}
+			events <- "delete " + row["name"].(string)
+		},
+	})
+
+	f.Update(`{"Bridge": {"` + otherId + `": {"new": {"name": "ignored", "ports": ["set", []], "external_ids": ["map", []]}}}}`)
+	f.Update(`{"Bridge": {"` + ovsdbtest.BridgeId + `": {"old": {"name": "br0"}, "new": {"name": "br1", "ports": ["set", []], "external_ids": ["map", []]}}}}`)
+	f.Update(`{"Bridge": {"` + ovsdbtest.BridgeId + `": {"old": {"name": "br1"}}}}`)
+
+	expected := []string{"add br0", "update br0 br1 br1", "delete br1"}
+	for _, e := range expected {
+		select {
+		case event := <-events:
+			if event != e {
+				t.Errorf("Got event %q, expected %q", event, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Event not received: " + e)
+		}
+	}
+	select {
+	case event := <-events:
+		t.Error("Filtered event received: " + event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCache_TypedRow(t *testing.T) {
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method == "monitor" {
+			return json.RawMessage(`{
+				"Open_vSwitch": {"` + ovsdbtest.RootId + `": {"new": {"next_cfg": 3, "bridges": ["uuid", "` + ovsdbtest.BridgeId + `"]}}},
+				"Bridge": {"` + ovsdbtest.BridgeId + `": {"new": {"name": "br0", "fail_mode": ["set", []],
+					"flood_vlans": ["set", [10, 20]], "external_ids": ["map", []], "ports": ["set", []]}}}
+			}`), nil
+		}
+		return nil, nil
+	})
+	cache := ovsdbtest.NewCache(t, f)
+
+	root, err := cache.TypedRow("Open_vSwitch", ovsdbtest.RootId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root["next_cfg"] != int64(3) || !reflect.DeepEqual(root["bridges"], ovshelper.Set{ovshelper.UUID(ovsdbtest.BridgeId)}) {
+		t.Error("Wrong root row:", root)
+	}
+
+	bridge, err := cache.Snapshot().TypedRow("Bridge", ovsdbtest.BridgeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(bridge["fail_mode"], ovshelper.Set{}) ||
+		!reflect.DeepEqual(bridge["flood_vlans"], ovshelper.Set{int64(10), int64(20)}) ||
+		!reflect.DeepEqual(bridge["external_ids"], ovshelper.Map{}) ||
+		bridge["name"] != "br0" {
+		t.Error("Wrong bridge row:", bridge)
+	}
+
+	// values can be written back as they are
+	encoded, _ := json.Marshal(bridge["flood_vlans"])
+	if string(encoded) != `["set",[10,20]]` {
+		t.Error("Wrong encoding: " + string(encoded))
+	}
+
+	// legacy data handles integer sets
+	if len(cache.GetMap("Bridge", "uuid", ovsdbtest.BridgeId, "flood_vlans")) != 2 {
+		t.Error("Integer set not in data")
+	}
+}
+
+func TestCache_SnapshotData(t *testing.T) {
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method == "monitor" {
+			return json.RawMessage(ovsdbtest.InitialUpdate), nil
+		}
+		return nil, nil
+	})
+	cache := &dbcache.Cache{
+		OVSDB:   f,
+		Schema:  "Open_vSwitch",
+		Indexes: map[string][]string{"Bridge": {"name"}},
+	}
+	err := cache.StartMonitor("Open_vSwitch", map[string][]string{
+		"Open_vSwitch": nil,
+		"Bridge":       nil,
+		"Port":         nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bridgeId := func(i int) string {
+		return fmt.Sprintf("%08x-0000-4000-8000-000000000000", i)
+	}
+	// enough rows to split trie leaves, with conflicting names
+	var rows []string
+	for i := 0; i < 300; i++ {
+		rows = append(rows, fmt.Sprintf(`"%s": {"new": {"name": "br%d", "ports": ["set", []]}}`, bridgeId(i), i%250))
+	}
+	f.Update(`{"Bridge": {` + strings.Join(rows, ",") + `}}`)
+	before := cache.Snapshot()
+
+	rows = nil
+	for i := 0; i < 300; i += 2 {
+		rows = append(rows, fmt.Sprintf(`"%s": {"old": {"name": "br%d"}}`, bridgeId(i), i%250))
+	}
+	f.Update(`{"Bridge": {` + strings.Join(rows, ",") + `}}`)
+	after := cache.Snapshot()
+
+	sorted := func(keys []string) []string {
+		sort.Strings(keys)
+		return keys
+	}
+	cache.RLock()
+	if !reflect.DeepEqual(after.GetMap(), cache.Data) {
+		t.Error("Snapshot data differs from cache data")
+	}
+	for _, path := range [][]string{{}, {"Port"}, {"Bridge"}, {"Bridge", "name"}, {"Bridge", "uuid"}, {"Bridge", "name", "br1"}} {
+		data := cache.Data
+		for _, key := range path {
+			data = data[key].(map[string]interface{})
+		}
+		var keys []string
+		for key := range data {
+			keys = append(keys, key)
+		}
+		if !reflect.DeepEqual(sorted(after.GetKeys(path...)), sorted(keys)) {
+			t.Errorf("Keys of %v differ from cache", path)
+		}
+	}
+	cache.RUnlock()
+
+	// legacy getters read snapshot, so they don't wait for locked cache
+	cache.Lock()
+	read := make(chan bool, 1)
+	go func() {
+		read <- len(cache.GetMap("Bridge", "uuid")) == 151 && len(cache.GetList("Bridge", "uuid")) == 151 && len(cache.GetKeys("Bridge", "uuid")) == 151
+	}()
+	select {
+	case ok := <-read:
+		if !ok {
+			t.Error("Wrong cache data")
+		}
+	case <-time.After(time.Second):
+		t.Error("Cache getters wait for cache lock")
+	}
+	cache.Unlock()
+	if after.GetMap("Bridge", "name", "br1")["uuid"] != bridgeId(1) || after.GetMap("Bridge", "name", "br3")["uuid"] != bridgeId(3) {
+		t.Error("Wrong row for index value")
+	}
+	// br1 is shared by rows 1 and 251, lowest uuid is used
+	if before.GetMap("Bridge", "name", "br1")["uuid"] != bridgeId(1) || len(before.GetKeys("Bridge", "uuid")) != 301 {
+		t.Error("Old snapshot changed")
+	}
+	if len(after.GetKeys("Bridge", "uuid")) != 151 || len(after.GetMap("Bridge", "name", "br2")) != 0 {
+		t.Error("Deleted rows in snapshot")
+	}
+}
+
+func TestCache_SnapshotVersions(t *testing.T) {
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method == "monitor" {
+			return json.RawMessage(ovsdbtest.InitialUpdate), nil
+		}
+		return nil, nil
+	})
+	cache := ovsdbtest.NewCache(t, f)
+
+	before := cache.Snapshot()
+	if before.Version != 1 || cache.Version() != 1 {
+		t.Error("Initial update is not version 1:", before.Version)
+	}
+
+	f.Update(`{"Bridge": {"` + ovsdbtest.BridgeId + `": {"old": {"name": "br0"}, "new": {"name": "br1"}}}}`)
+
+	after := cache.Snapshot()
+	if after.Version != 2 {
+		t.Error("Update did not make new version:", after.Version)
+	}
+	if before.Row("Bridge", ovsdbtest.BridgeId)["name"] != "br0" || before.GetMap("Bridge", "name", "br0")["uuid"] != ovsdbtest.BridgeId {
+		t.Error("Old snapshot changed")
+	}
+	if after.Row("Bridge", ovsdbtest.BridgeId)["name"] != "br1" || len(after.GetKeys("Bridge", "name", "br0")) != 0 {
+		t.Error("New snapshot does not have update")
+	}
+	if after.Row("Open_vSwitch", ovsdbtest.RootId)["next_cfg"] == nil {
+		t.Error("Unchanged table lost")
+	}
+
+	// readers do not block updates
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s := cache.Snapshot()
+				if len(s.RowIds("Bridge")) != 1 {
+					t.Error("Inconsistent snapshot")
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		f.Update(`{"Bridge": {"` + ovsdbtest.BridgeId + `": {"old": {"name": "x"}, "new": {"name": "br` + fmt.Sprint(i) + `"}}}}`)
+	}
+	wg.Wait()
+	if cache.Version() != 102 {
+		t.Error("Wrong version:", cache.Version())
+	}
+}
+
+func TestCache_Status(t *testing.T) {
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method == "monitor_cond_since" {
+			if args[3] != "00000000-0000-0000-0000-000000000000" {
+				t.Error("Wrong last txn id:", args[3])
+			}
+			return json.RawMessage(`[false, "txn-1", {
+				"Open_vSwitch": {"` + ovsdbtest.RootId + `": {"initial": {"next_cfg": 3}}},
+				"Bridge": {"` + ovsdbtest.BridgeId + `": {"initial": {"name": "br0", "flood_vlans": ["set", [1, 2]], "external_ids": ["map", [["a", "1"], ["b", "2"]]]}}}
+			}]`), nil
+		}
+		return nil, nil
+	})
+	cache := &dbcache.Cache{
+		OVSDB:  f,
+		Schema: "Open_vSwitch",
+		Method: "monitor_cond_since",
+	}
+	if cache.Ready() {
+		t.Error("Cache ready before start")
+	}
+	err := cache.StartMonitor("Open_vSwitch", map[string][]string{
+		"Open_vSwitch": nil,
+		"Bridge":       {"name", "fail_mode", "flood_vlans", "external_ids"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cache.Ready() || cache.Generation() != 1 || cache.LastTxnId() != "txn-1" || cache.LastUpdate().IsZero() {
+		t.Error("Wrong status after start")
+	}
+
+	// default values left out from update2 rows are filled in
+	bridge, _ := cache.TypedRow("Bridge", ovsdbtest.BridgeId)
+	if !reflect.DeepEqual(bridge["fail_mode"], ovshelper.Set{}) {
+		t.Error("Default value not filled:", bridge)
+	}
+
+	done := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		done <- cache.WaitFor(ctx, func(s *dbcache.Snapshot) bool {
+			return s.Row("Bridge", ovsdbtest.RootId) != nil
+		})
+	}()
+
+	f.Update(`["txn-2", {"Bridge": {
+		"` + ovsdbtest.BridgeId + `": {"modify": {"flood_vlans": ["set", [2, 3]], "external_ids": ["map", [["a", "1"], ["b", "3"], ["c", "4"]]], "fail_mode": "secure"}},
+		"` + ovsdbtest.RootId + `": {"insert": {"name": "br1"}}
+	}}]`)
+	if err := <-done; err != nil {
+		t.Error("WaitFor failed:", err)
+	}
+
+	bridge, _ = cache.TypedRow("Bridge", ovsdbtest.BridgeId)
+	if !ovshelper.DatumEqual(bridge["flood_vlans"], ovshelper.Set{int64(1), int64(3)}) ||
+		!ovshelper.DatumEqual(bridge["external_ids"], ovshelper.Map{"b": "3", "c": "4"}) ||
+		!ovshelper.DatumEqual(bridge["fail_mode"], ovshelper.Set{"secure"}) {
+		t.Error("Diff not applied:", bridge)
+	}
+	if cache.LastTxnId() != "txn-2" {
+		t.Error("Last txn id not updated")
+	}
+
+	f.Update(`["txn-3", {"Bridge": {"` + ovsdbtest.RootId + `": {"delete": null}}}]`)
+	if cache.Snapshot().Row("Bridge", ovsdbtest.RootId) != nil {
+		t.Error("Row not deleted")
+	}
+
+	f.Close()
+	if cache.Ready() {
+		t.Error("Cache ready after disconnect")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := cache.WaitFor(ctx, func(s *dbcache.Snapshot) bool { return true }); err == nil {
+		t.Error("WaitFor succeeded on stale cache")
+	}
+
+	if err := cache.StartMonitor("Open_vSwitch", map[string][]string{"Open_vSwitch": nil, "Bridge": nil}); err != nil {
+		t.Fatal(err)
+	}
+	if !cache.Ready() || cache.Generation() != 2 || cache.Snapshot().Row("Bridge", ovsdbtest.BridgeId)["name"] != "br0" {
+		t.Error("Cache not refilled")
+	}
+}
+
+func TestCache_SaveLoad(t *testing.T) {
+	var since interface{}
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method != "monitor_cond_since" {
+			return nil, nil
+		}
+		since = args[3]
+		switch since {
+		case "txn-1":
+			return json.RawMessage(`[true, "txn-2", {"Bridge": {"` + ovsdbtest.BridgeId + `": {"modify": {"flood_vlans": ["set", [5]]}}}}]`), nil
+		case "txn-2":
+			// server no longer has the transaction, all rows are sent
+			return json.RawMessage(`[false, "txn-3", {"Bridge": {"` + ovsdbtest.RootId + `": {"initial": {"name": "br1"}}}}]`), nil
+		}
+		return json.RawMessage(`[false, "txn-1", {
+			"Bridge": {"` + ovsdbtest.BridgeId + `": {"initial": {"name": "br0", "flood_vlans": ["set", [1, 2]]}}}
+		}]`), nil
+	})
+	tables := map[string][]string{"Bridge": {"name", "flood_vlans"}}
+	newCache := func() *dbcache.Cache {
+		return &dbcache.Cache{
+			OVSDB:   f,
+			Schema:  "Open_vSwitch",
+			Method:  "monitor_cond_since",
+			Indexes: map[string][]string{"Bridge": {"name"}},
+		}
+	}
+	path := t.TempDir() + "/cache.json"
+
+	cache := newCache()
+	if err := cache.StartMonitor("Open_vSwitch", tables); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	cache = newCache()
+	if err := cache.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Ready() || cache.LastTxnId() != "txn-1" || cache.GetMap("Bridge", "name", "br0") == nil {
+		t.Error("Wrong cache state after load")
+	}
+
+	if err := cache.StartMonitor("Open_vSwitch", tables); err != nil {
+		t.Fatal(err)
+	}
+	bridge, _ := cache.TypedRow("Bridge", ovsdbtest.BridgeId)
+	if since != "txn-1" || !cache.Ready() || cache.LastTxnId() != "txn-2" {
+		t.Error("Monitor not resumed from saved transaction")
+	}
+	if bridge["name"] != "br0" || !ovshelper.DatumEqual(bridge["flood_vlans"], ovshelper.Set{int64(1), int64(2), int64(5)}) {
+		t.Error("Changes not applied to loaded rows:", bridge)
+	}
+	if uuids, _ := cache.Lookup("Bridge", "name", "br0"); len(uuids) != 1 {
+		t.Error("Loaded rows not indexed")
+	}
+
+	// rows fetched again replace loaded ones
+	if err := cache.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	cache = newCache()
+	cache.Load(path)
+	cache.StartMonitor("Open_vSwitch", tables)
+	if since != "txn-2" || cache.Snapshot().Row("Bridge", ovsdbtest.BridgeId) != nil || cache.Snapshot().Row("Bridge", ovsdbtest.RootId) == nil {
+		t.Error("Rows not replaced when transaction is not found")
+	}
+
+	// other columns can't be resumed
+	cache = newCache()
+	cache.Load(path)
+	cache.StartMonitor("Open_vSwitch", map[string][]string{"Bridge": {"name"}})
+	if since != "00000000-0000-0000-0000-000000000000" {
+		t.Error("Monitor resumed for different columns")
+	}
+
+	cache = &dbcache.Cache{Schema: "OVN_Southbound"}
+	if err := cache.Load(path); err == nil {
+		t.Error("File of other database loaded")
+	}
+}
+
+func TestCache_Reconfigure(t *testing.T) {
+	var calls []string
+	var changeArgs []interface{}
+	var monitors []interface{}
+	f := ovsdbtest.NewFakeOVSDB(nil)
+	f.Handler = func(method string, args []interface{}) (json.RawMessage, error) {
+		calls = append(calls, method)
+		switch method {
+		case "monitor_cond":
+			monitors = append(monitors, args[2])
+			if len(monitors) == 1 {
+				return json.RawMessage(`{"Bridge": {"` + ovsdbtest.BridgeId + `": {"initial": {"name": "br0"}}}}`), nil
+			}
+			return json.RawMessage(`{
+				"Open_vSwitch": {"` + ovsdbtest.RootId + `": {"initial": {"next_cfg": 1}}},
+				"Bridge": {
+					"` + ovsdbtest.BridgeId + `": {"initial": {"name": "br0"}},
+					"` + ovsdbtest.RootId + `": {"initial": {"name": "br1", "fail_mode": "secure"}}
+				}
+			}`), nil
+		case "monitor_cond_change":
+			changeArgs = args
+			f.NotifyMonitor(args[1].(string), `{"Bridge": {"`+ovsdbtest.RootId+`": {"insert": {"name": "br1"}}}}`)
+			return json.RawMessage(`{}`), nil
+		case "monitor_cancel":
+			return json.RawMessage(`{}`), nil
+		}
+		return nil, nil
+	}
+
+	cache := &dbcache.Cache{OVSDB: f, Schema: "Open_vSwitch"}
+	err := cache.Start("Open_vSwitch", map[string]dbcache.TableConfig{
+		"Bridge": {
+			Columns: []string{"name", "fail_mode"},
+			Where:   dbtransaction.Where(dbtransaction.Equal("name", "br0")),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.Method != "monitor_cond" {
+		t.Error("Conditional method not used for conditions, got", cache.Method)
+	}
+	request, _ := json.Marshal(monitors[0])
+	if string(request) != `{"Bridge":{"columns":["name","fail_mode"],"select":{"delete":true,"initial":true,"insert":true,"modify":true},"where":[["name","==","br0"]]}}` {
+		t.Error("Wrong monitor request:", string(request))
+	}
+
+	events := make(chan string, 10)
+	cache.AddEventHandler(dbcache.EventHandler{
+		Table: "Bridge",
+		OnAdd: func(s *dbcache.Snapshot, uuid string, row map[string]interface{}) {
+			events <- "add " + row["name"].(string)
+		},
+		OnUpdate: func(s *dbcache.Snapshot, uuid string, old map[string]interface{}, new map[string]interface{}) {
+			events <- fmt.Sprint("update ", new["name"], " ", new["fail_mode"])
+		},
+		OnDelete: func(s *dbcache.Snapshot, uuid string, row map[string]interface{}) {
+			events <- "delete " + row["name"].(string)
+		},
+	})
+	expectEvent := func(expected string) {
+		select {
+		case event := <-events:
+			if event != expected {
+				t.Error("Expected event", expected, "got", event)
+			}
+		case <-time.After(time.Second):
+			t.Error("No event", expected)
+		}
+	}
+	expectEvent("add br0")
+
+	// only conditions change, so monitor is kept
+	err = cache.Reconfigure(map[string]dbcache.TableConfig{
+		"Bridge": {
+			Columns: []string{"fail_mode", "name"},
+			Where:   dbtransaction.Where(dbtransaction.Includes("name", ovshelper.Set{"br0", "br1"})),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, _ := json.Marshal(changeArgs)
+	if len(monitors) != 1 || !strings.HasSuffix(string(encoded), `{"Bridge":[{"where":[["name","includes",["set",["br0","br1"]]]]}]}]`) {
+		t.Error("Wrong monitor_cond_change request:", string(encoded))
+	}
+	if ids := f.MonitorIds(); len(ids) != 1 || ids[0] == changeArgs[0].(string) {
+		t.Error("Callback of old monitor id not removed")
+	}
+	expectEvent("add br1")
+	if where, _ := json.Marshal(cache.Snapshot().Conditions("Bridge")); string(where) != `[["name","includes",["set",["br0","br1"]]]]` {
+		t.Error("Snapshot has old conditions:", string(where))
+	}
+
+	// updates come with new monitor id
+	f.NotifyMonitor(changeArgs[1].(string), `{"Bridge": {"`+ovsdbtest.BridgeId+`": {"modify": {"fail_mode": "standalone"}}}}`)
+	expectEvent("update br0 [set [standalone]]")
+
+	// new table needs new monitor, only changed rows are reported
+	err = cache.Reconfigure(map[string]dbcache.TableConfig{
+		"Open_vSwitch": {},
+		"Bridge":       {Columns: []string{"name", "fail_mode"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(monitors) != 2 || calls[len(calls)-1] != "monitor_cancel" {
+		t.Error("Monitor not replaced:", calls)
+	}
+	expectEvent("update br1 secure")
+	expectEvent("update br0 [set []]")
+	if cache.Snapshot().Row("Open_vSwitch", ovsdbtest.RootId) == nil {
+		t.Error("New table not cached")
+	}
+	if cache.Snapshot().Conditions("Bridge") != nil {
+		t.Error("Conditions of unconditional table")
+	}
+	if uuids, _ := cache.Lookup("Bridge", "name", "br1"); len(uuids) != 1 {
+		t.Error("Indexes not rebuilt")
+	}
+
+	// notifications of replaced monitor are ignored
+	f.NotifyMonitor(changeArgs[1].(string), `{"Bridge": {"`+ovsdbtest.BridgeId+`": {"delete": null}}}`)
+	if cache.Snapshot().Row("Bridge", ovsdbtest.BridgeId) == nil {
+		t.Error("Notification of old monitor applied")
+	}
+
+	if err := cache.Reconfigure(map[string]dbcache.TableConfig{"Missing": {}}); err == nil {
+		t.Error("Unknown table accepted")
+	}
+
+	// replacing monitor is watched like the first one
+	for _, id := range f.MonitorIds() {
+		f.Cancel(id)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for cache.Ready() && ctx.Err() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	if cache.Ready() {
+		t.Error("Cache ready after replacing monitor was canceled")
+	}
+}
+
+func TestMultiCache(t *testing.T) {
+	const dbId = "3c4d5e6f-7a8b-4c9d-8e0f-1a2b3c4d5e6f"
+	monitorIds := map[string]string{}
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		switch {
+		case method == "get_schema" && args[0] == "_Server":
+			return json.RawMessage(`{"name": "_Server", "version": "1.1.0", "tables": {
+				"Database": {"columns": {"name": {"type": "string"}, "connected": {"type": "boolean"}}}
+			}}`), nil
+		case method == "monitor" && args[0] == "_Server":
+			monitorIds["_Server"] = args[1].(string)
+			return json.RawMessage(`{"Database": {"` + dbId + `": {"new": {"name": "Open_vSwitch", "connected": true}}}}`), nil
+		case method == "monitor":
+			monitorIds["Open_vSwitch"] = args[1].(string)
+			return json.RawMessage(ovsdbtest.InitialUpdate), nil
+		}
+		return nil, nil
+	})
+
+	multi := dbcache.NewMultiCache()
+	server := &dbcache.Cache{OVSDB: f, Schema: "_Server", Indexes: map[string][]string{"Database": {"name"}}}
+	vswitch := &dbcache.Cache{OVSDB: f, Schema: "Open_vSwitch"}
+	for _, cache := range []*dbcache.Cache{server, vswitch} {
+		if err := multi.Add(cache); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := multi.Add(&dbcache.Cache{Schema: "_Server"}); err == nil {
+		t.Error("Database added twice")
+	}
+	if multi.Ready() {
+		t.Error("Ready before caches are started")
+	}
+	if err := server.StartMonitor("_Server", map[string][]string{"Database": nil}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vswitch.StartMonitor("Open_vSwitch", map[string][]string{"Bridge": nil}); err != nil {
+		t.Fatal(err)
+	}
+	if !multi.Ready() || !reflect.DeepEqual(multi.Databases(), []string{"Open_vSwitch", "_Server"}) {
+		t.Error("Wrong databases", multi.Databases())
+	}
+
+	before := multi.Snapshot()
+	if before.GetMap("_Server", "Database", "name", "Open_vSwitch")["connected"] != true ||
+		before.Row("Open_vSwitch", "Bridge", ovsdbtest.BridgeId)["name"] != "br0" {
+		t.Error("Rows of databases missing in snapshot")
+	}
+
+	done := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		done <- multi.WaitFor(ctx, func(s *dbcache.MultiSnapshot) bool {
+			return s.Row("_Server", "Database", dbId)["connected"] == false
+		})
+	}()
+
+	f.NotifyMonitor(monitorIds["Open_vSwitch"], `{"Bridge": {"`+ovsdbtest.BridgeId+`": {"old": {"name": "br0"}, "new": {"name": "br2"}}}}`)
+	f.NotifyMonitor(monitorIds["_Server"], `{"Database": {"`+dbId+`": {"old": {"connected": true}, "new": {"name": "Open_vSwitch", "connected": false}}}}`)
+	if err := <-done; err != nil {
+		t.Error("WaitFor failed:", err)
+	}
+
+	after := multi.Snapshot()
+	if after.Version != before.Version+2 || after.Row("Open_vSwitch", "Bridge", ovsdbtest.BridgeId)["name"] != "br2" {
+		t.Error("Snapshot not updated")
+	}
+	if before.Row("Open_vSwitch", "Bridge", ovsdbtest.BridgeId)["name"] != "br0" || before.Row("_Server", "Database", dbId)["connected"] != true {
+		t.Error("Old snapshot changed")
+	}
+	if uuids, err := multi.Lookup("_Server", "Database", "name", "Open_vSwitch"); err != nil || len(uuids) != 1 {
+		t.Error("Lookup in database failed:", uuids, err)
+	}
+	if _, err := multi.Lookup("OVN_Northbound", "NB_Global", "name", "x"); err == nil {
+		t.Error("Lookup in unknown database succeeded")
+	}
+	if multi.Database("OVN_Northbound") != nil || after.Database("OVN_Northbound").HasTable("NB_Global") {
+		t.Error("Unknown database found")
+	}
+}
+
+func TestCache_References(t *testing.T) {
+	const port1 = "4d5e6f7a-8b9c-4d0e-9f1a-2b3c4d5e6f7a"
+	const port2 = "5e6f7a8b-9c0d-4e1f-8a2b-3c4d5e6f7a8b"
+	const iface1 = "6f7a8b9c-0d1e-4f2a-9b3c-4d5e6f7a8b9c"
+	const iface2 = "7a8b9c0d-1e2f-4a3b-8c4d-5e6f7a8b9c0d"
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method == "monitor" {
+			return json.RawMessage(`{
+				"Open_vSwitch": {"` + ovsdbtest.RootId + `": {"new": {"next_cfg": 1, "bridges": ["uuid", "` + ovsdbtest.BridgeId + `"]}}},
+				"Bridge": {"` + ovsdbtest.BridgeId + `": {"new": {"name": "br0", "ports": ["set", [["uuid", "` + port2 + `"], ["uuid", "` + port1 + `"]]]}}},
+				"Port": {
+					"` + port1 + `": {"new": {"name": "p1", "interfaces": ["uuid", "` + iface1 + `"]}},
+					"` + port2 + `": {"new": {"name": "p2", "interfaces": ["set", [["uuid", "` + iface1 + `"], ["uuid", "` + iface2 + `"]]]}}
+				},
+				"Interface": {
+					"` + iface1 + `": {"new": {"name": "i1"}},
+					"` + iface2 + `": {"new": {"name": "i2"}}
+				}
+			}`), nil
+		}
+		return nil, nil
+	})
+	cache := &dbcache.Cache{OVSDB: f, Schema: "Open_vSwitch", Indexes: map[string][]string{"Bridge": {"name"}}}
+	err := cache.StartMonitor("Open_vSwitch", map[string][]string{
+		"Open_vSwitch": {"next_cfg", "bridges"},
+		"Bridge":       {"name", "ports"},
+		"Port":         {"name", "interfaces"},
+		"Interface":    {"name"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(rows []dbcache.RefRow) []string {
+		list := []string{}
+		for _, row := range rows {
+			list = append(list, row.Row["name"].(string))
+		}
+		return list
+	}
+
+	// both raw rows and rows of Data can be resolved
+	ports, err := cache.Resolve("Bridge", cache.Snapshot().Row("Bridge", ovsdbtest.BridgeId), "ports")
+	if err != nil || !reflect.DeepEqual(names(ports), []string{"p1", "p2"}) {
+		t.Error("Wrong ports resolved:", ports, err)
+	}
+	ports, err = cache.Resolve("Bridge", cache.GetMap("Bridge", "name", "br0"), "ports")
+	if err != nil || !reflect.DeepEqual(names(ports), []string{"p1", "p2"}) {
+		t.Error("Wrong ports resolved from Data:", ports, err)
+	}
+	interfaces, err := cache.Follow("Open_vSwitch", ovsdbtest.RootId, "bridges", "ports", "interfaces")
+	if err != nil || !reflect.DeepEqual(names(interfaces), []string{"i1", "i2"}) {
+		t.Error("Wrong interfaces followed:", interfaces, err)
+	}
+	if _, err := cache.Resolve("Bridge", nil, "name"); err == nil {
+		t.Error("Resolved column which is not a reference")
+	}
+
+	expected := []dbcache.Referrer{
+		{Table: "Port", Column: "interfaces", UUID: port1},
+		{Table: "Port", Column: "interfaces", UUID: port2},
+	}
+	if referrers := cache.Referrers("Interface", iface1); !reflect.DeepEqual(referrers, expected) {
+		t.Error("Wrong referrers:", referrers)
+	}
+
+	old := cache.Snapshot()
+	f.Update(`{
+		"Bridge": {"` + ovsdbtest.BridgeId + `": {"old": {"ports": ["set", []]}, "new": {"name": "br0", "ports": ["uuid", "` + port1 + `"]}}},
+		"Port": {"` + port2 + `": {"old": {"name": "p2"}}}
+	}`)
+	if referrers := cache.Referrers("Port", port2); len(referrers) != 0 {
+		t.Error("Referrers of removed reference kept:", referrers)
+	}
+	if referrers := cache.Referrers("Interface", iface1); len(referrers) != 1 || referrers[0].UUID != port1 {
+		t.Error("Referrers of deleted row kept:", referrers)
+	}
+	if referrers := cache.Referrers("Bridge", ovsdbtest.BridgeId); len(referrers) != 1 || referrers[0].Table != "Open_vSwitch" {
+		t.Error("Wrong bridge referrers:", referrers)
+	}
+
+	// snapshots keep referrers of their version
+	if referrers := old.Referrers("Interface", iface1); !reflect.DeepEqual(referrers, expected) {
+		t.Error("Referrers of old snapshot changed:", referrers)
+	}
+	if referrers := old.Referrers("Port", port2); len(referrers) != 1 || referrers[0].UUID != ovsdbtest.BridgeId {
+		t.Error("Wrong referrers of old snapshot:", referrers)
+	}
+}
+
+func TestCache_Query(t *testing.T) {
+	bridges := map[string]string{
+		"8b9c0d1e-2f3a-4b4c-9d5e-6f7a8b9c0d1e": `{"name": "br-a", "fail_mode": "secure", "flood_vlans": ["set", [10, 20]]}`,
+		"9c0d1e2f-3a4b-4c5d-8e6f-7a8b9c0d1e2f": `{"name": "br-c", "fail_mode": ["set", []], "flood_vlans": ["set", []]}`,
+		"0d1e2f3a-4b5c-4d6e-9f7a-8b9c0d1e2f3a": `{"name": "br-b", "fail_mode": "standalone", "flood_vlans": 30}`,
+	}
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method == "monitor" {
+			rows := []string{}
+			for uuid, row := range bridges {
+				rows = append(rows, `"`+uuid+`": {"new": `+row+`}`)
+			}
+			return json.RawMessage(`{"Bridge": {` + strings.Join(rows, ",") + `}}`), nil
+		}
+		return nil, nil
+	})
+	cache := &dbcache.Cache{OVSDB: f, Schema: "Open_vSwitch"}
+	if err := cache.StartMonitor("Open_vSwitch", map[string][]string{"Bridge": {"name", "fail_mode", "flood_vlans"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(rows []map[string]interface{}) []string {
+		list := []string{}
+		for _, row := range rows {
+			list = append(list, row["name"].(string))
+		}
+		return list
+	}
+
+	rows, err := cache.Query(dbcache.Query{Table: "Bridge", OrderBy: "name", Desc: true})
+	if err != nil || !reflect.DeepEqual(names(rows), []string{"br-c", "br-b", "br-a"}) {
+		t.Error("Wrong order:", rows, err)
+	}
+	if rows[0]["_uuid"] == nil || rows[0]["flood_vlans"] == nil {
+		t.Error("All columns not selected:", rows[0])
+	}
+
+	rows, err = cache.Query(dbcache.Query{
+		Table:   "Bridge",
+		Where:   dbtransaction.Where(dbtransaction.NotEqual("name", "br-a")),
+		Columns: []string{"_uuid", "name"},
+		OrderBy: "fail_mode",
+		Limit:   1,
+	})
+	if err != nil || len(rows) != 1 || len(rows[0]) != 2 || rows[0]["name"] != "br-c" {
+		t.Error("Wrong projection or limit:", rows, err)
+	}
+
+	rows, err = cache.Query(dbcache.Query{
+		Table: "Bridge",
+		Filter: func(uuid string, row map[string]interface{}) bool {
+			for _, vlan := range row["flood_vlans"].(ovshelper.Set) {
+				if vlan.(int64) >= 20 {
+					return true
+				}
+			}
+			return false
+		},
+		OrderBy: "flood_vlans",
+	})
+	if err != nil || !reflect.DeepEqual(names(rows), []string{"br-a", "br-b"}) {
+		t.Error("Wrong filtered rows:", rows, err)
+	}
+
+	// the same select can be sent to server or answered from cache
+	s := dbtransaction.Select{
+		Table:   "Bridge",
+		Columns: []string{"name"},
+		Where:   dbtransaction.Where(dbtransaction.Includes("flood_vlans", 30)),
+	}
+	rows, err = cache.Query(s.Query())
+	if err != nil || !reflect.DeepEqual(rows, []map[string]interface{}{{"name": "br-b"}}) {
+		t.Error("Wrong rows of select query:", rows, err)
+	}
+
+	if _, err := cache.Query(dbcache.Query{Table: "Bridge", Columns: []string{"ports"}}); err == nil {
+		t.Error("Column which is not cached selected")
+	}
+	if _, err := cache.Query(dbcache.Query{Table: "Port"}); err == nil {
+		t.Error("Table which is not cached queried")
+	}
+}
+
+// readerOVSDB sends notification like connection reader whenever close
+// callback is registered.
+type readerOVSDB struct {
+	*ovsdbtest.FakeOVSDB
+}
+
+func (f readerOVSDB) AddCloseCallback(id string, callback func()) {
+	go f.Update(`{"Bridge": {"` + ovsdbtest.BridgeId + `": {"old": {"name": "br0"}, "new": {"name": "br1"}}}}`)
+	time.Sleep(10 * time.Millisecond)
+	f.FakeOVSDB.AddCloseCallback(id, callback)
+}
+
+// notification coming while cache starts must not deadlock it
+func TestCache_StartWithUpdate(t *testing.T) {
+	f := readerOVSDB{ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method == "monitor" {
+			return json.RawMessage(ovsdbtest.InitialUpdate), nil
+		}
+		return nil, nil
+	})}
+	cache := &dbcache.Cache{OVSDB: f, Schema: "Open_vSwitch"}
+	started := make(chan error, 1)
+	go func() {
+		started <- cache.StartMonitor("Open_vSwitch", map[string][]string{"Bridge": nil})
+	}()
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start deadlocked with connection reader")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := cache.WaitFor(ctx, func(s *dbcache.Snapshot) bool {
+		return s.Row("Bridge", ovsdbtest.BridgeId)["name"] == "br1"
+	})
+	if err != nil {
+		t.Error("Notification not applied:", err)
+	}
+}
+
+// cache of canceled monitor is stale
+func TestCache_MonitorCanceled(t *testing.T) {
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method == "monitor" {
+			return json.RawMessage(ovsdbtest.InitialUpdate), nil
+		}
+		return nil, nil
+	})
+	cache := ovsdbtest.NewCache(t, f)
+	for _, id := range f.MonitorIds() {
+		f.Cancel(id)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for cache.Ready() && ctx.Err() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	if cache.Ready() {
+		t.Error("Cache ready after monitor was canceled")
+	}
+}
+
+func TestCache_Stop(t *testing.T) {
+	var canceled []string
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		switch method {
+		case "monitor":
+			return json.RawMessage(ovsdbtest.InitialUpdate), nil
+		case "monitor_cancel":
+			canceled = append(canceled, args[0].(string))
+		}
+		return nil, nil
+	})
+	cache := ovsdbtest.NewCache(t, f)
+	if err := cache.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Ready() || len(canceled) != 1 || len(f.MonitorIds()) != 0 {
+		t.Error("Monitor not stopped:", canceled, f.MonitorIds())
+	}
+	if cache.Snapshot().Row("Bridge", ovsdbtest.BridgeId) == nil {
+		t.Error("Rows dropped on stop")
+	}
+
+	// stopped cache can be started again
+	if err := cache.StartMonitor("Open_vSwitch", map[string][]string{"Bridge": nil}); err != nil || !cache.Ready() {
+		t.Error("Cache not restarted:", err)
+	}
+}
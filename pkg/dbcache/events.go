@@ -0,0 +1,192 @@
+package dbcache
+
+import (
+	"sort"
+	"sync"
+)
+
+// EventHandler receives changes of one table after cache has applied them.
+// Rows are in OVSDB notation and belong to the handler. Snapshot is the cache
+// state right after the update which caused the event, so further reads are
+// consistent with the event.
+//
+// Handlers run in a separate goroutine, one event at a time in update order.
+// They can use cache and make transactions, but slow handlers delay
+// following events.
+type EventHandler struct {
+	Table string
+	// Filter selects rows of interest, nil selects all. Update is passed to
+	// handler if old or new row matches, so handler sees rows leaving filter.
+	Filter   func(row map[string]interface{}) bool
+	OnAdd    func(snapshot *Snapshot, uuid string, row map[string]interface{})
+	OnUpdate func(snapshot *Snapshot, uuid string, old map[string]interface{}, new map[string]interface{})
+	OnDelete func(snapshot *Snapshot, uuid string, row map[string]interface{})
+}
+
+type rowChange struct {
+	table string
+	uuid  string
+	old   map[string]interface{}
+	new   map[string]interface{}
+}
+
+type eventBatch struct {
+	seq      uint64
+	snapshot *Snapshot
+	changes  []rowChange
+	handler  int // if set, batch is only for this handler
+}
+
+type handlerEntry struct {
+	*EventHandler
+	since uint64 // first batch for handler, earlier ones are older than its initial rows
+}
+
+type events struct {
+	sync.Mutex
+	handlers map[int]handlerEntry
+	nextId   int
+	seq      uint64
+	queue    []eventBatch
+	running  bool
+	changes  []rowChange // changes of update being applied, guarded by cache lock
+}
+
+// AddEventHandler registers handler and returns its id. OnAdd is called for
+// rows already in cache, so handler sees all rows.
+func (cache *Cache) AddEventHandler(handler EventHandler) int {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if cache.events == nil {
+		cache.events = &events{handlers: make(map[int]handlerEntry)}
+	}
+	e := cache.events
+
+	e.Lock()
+	e.nextId++
+	id := e.nextId
+	// cache is locked, so next batch is either initial rows or next update
+	e.handlers[id] = handlerEntry{EventHandler: &handler, since: e.seq + 1}
+	e.Unlock()
+
+	var changes []rowChange
+	for _, uuid := range sortedRowIds(cache.rows[handler.Table]) {
+		changes = append(changes, rowChange{table: handler.Table, uuid: uuid, new: cache.rows[handler.Table][uuid]})
+	}
+	if len(changes) > 0 {
+		e.enqueue(eventBatch{snapshot: cache.Snapshot(), changes: changes, handler: id})
+	}
+
+	return id
+}
+
+// RemoveEventHandler unregisters handler, events already queued for it are
+// dropped.
+func (cache *Cache) RemoveEventHandler(id int) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if cache.events == nil {
+		return
+	}
+	cache.events.Lock()
+	delete(cache.events.handlers, id)
+	cache.events.Unlock()
+}
+
+// recordChange stores row change for handlers. Must be called with cache
+// locked.
+func (cache *Cache) recordChange(table string, uuid string, old map[string]interface{}, new map[string]interface{}) {
+	if cache.events == nil {
+		return
+	}
+	cache.events.changes = append(cache.events.changes, rowChange{table: table, uuid: uuid, old: old, new: new})
+}
+
+// flushEvents queues changes recorded during update together with snapshot
+// of updated cache. Must be called with cache locked.
+func (cache *Cache) flushEvents() {
+	if cache.events == nil || len(cache.events.changes) == 0 {
+		return
+	}
+	changes := cache.events.changes
+	cache.events.changes = nil
+	cache.events.enqueue(eventBatch{snapshot: cache.Snapshot(), changes: changes})
+}
+
+func (e *events) enqueue(batch eventBatch) {
+	e.Lock()
+	defer e.Unlock()
+
+	e.seq++
+	batch.seq = e.seq
+	e.queue = append(e.queue, batch)
+	if !e.running {
+		e.running = true
+		go e.dispatch()
+	}
+}
+
+// dispatch calls handlers until queue is empty.
+func (e *events) dispatch() {
+	for {
+		e.Lock()
+		if len(e.queue) == 0 {
+			e.running = false
+			e.Unlock()
+			return
+		}
+		batch := e.queue[0]
+		e.queue = e.queue[1:]
+		e.Unlock()
+
+		for _, change := range batch.changes {
+			for _, handler := range e.tableHandlers(change.table, batch) {
+				handler.call(batch.snapshot, change)
+			}
+		}
+	}
+}
+
+// tableHandlers returns currently registered handlers for table in order of
+// registration.
+func (e *events) tableHandlers(table string, batch eventBatch) []*EventHandler {
+	e.Lock()
+	defer e.Unlock()
+
+	ids := make([]int, 0, len(e.handlers))
+	for id, entry := range e.handlers {
+		if entry.Table == table && entry.since <= batch.seq && (batch.handler == 0 || id == batch.handler) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	handlers := make([]*EventHandler, len(ids))
+	for i, id := range ids {
+		handlers[i] = e.handlers[id].EventHandler
+	}
+	return handlers
+}
+
+func (handler *EventHandler) matches(row map[string]interface{}) bool {
+	return row != nil && (handler.Filter == nil || handler.Filter(copyRow(row)))
+}
+
+func (handler *EventHandler) call(snapshot *Snapshot, change rowChange) {
+	switch {
+	case change.old == nil:
+		if handler.OnAdd != nil && handler.matches(change.new) {
+			handler.OnAdd(snapshot, change.uuid, copyRow(change.new))
+		}
+	case change.new == nil:
+		if handler.OnDelete != nil && handler.matches(change.old) {
+			handler.OnDelete(snapshot, change.uuid, copyRow(change.old))
+		}
+	default:
+		if handler.OnUpdate != nil && (handler.matches(change.old) || handler.matches(change.new)) {
+			handler.OnUpdate(snapshot, change.uuid, copyRow(change.old), copyRow(change.new))
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package dbcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Index specification is a comma separated list of index parts. Part is a
+// column name, or column:key for value of map column key, for example
+// "name", "name,datapath" or "external_ids:iface-id". Indexes on any atomic
+// types and on sets are supported. Rows which have no value for some part
+// (empty optional column or missing map key) are not indexed.
+type index struct {
+	parts   []indexPart
+	columns map[string]ovshelper.Column // column definitions from schema, nil if unknown
+	unique  bool                        // declared in schema
+	keys    map[string]map[string]bool  // keys[key][uuid]
+	rowKeys map[string]string           // rowKeys[uuid] = key
+}
+
+type indexPart struct {
+	column string
+	key    string // map key, empty for whole column
+	isKey  bool
+}
+
+func parseIndex(spec string) []indexPart {
+	var parts []indexPart
+	for _, part := range strings.Split(spec, ",") {
+		column, key, isKey := strings.Cut(part, ":")
+		parts = append(parts, indexPart{column: column, key: key, isKey: isKey})
+	}
+	return parts
+}
+
+func newIndex(spec string, unique bool, columns map[string]ovshelper.Column) *index {
+	return &index{
+		parts:   parseIndex(spec),
+		columns: columns,
+		unique:  unique,
+		keys:    make(map[string]map[string]bool),
+		rowKeys: make(map[string]string),
+	}
+}
+
+// isLegacyIndex tells whether index is kept in Data as well, which is only
+// done for single column indexes.
+func isLegacyIndex(spec string) bool {
+	return !strings.ContainsAny(spec, ",:")
+}
+
+// tableColumns returns column definitions of table, nil if schema is unknown.
+func tableColumns(schema *ovshelper.Schema, table string) map[string]ovshelper.Column {
+	if schema == nil {
+		return nil
+	}
+	return schema.Tables[table].Columns
+}
+
+// partType returns schema type of index part values.
+func partType(columns map[string]ovshelper.Column, part indexPart) (ovshelper.Type, bool) {
+	def, ok := columns[part.column]
+	if !ok {
+		return ovshelper.Type{}, false
+	}
+	if part.isKey {
+		if !def.Type.IsMap() {
+			return ovshelper.Type{}, false
+		}
+		return ovshelper.Type{Key: def.Type.Value, Min: 1, Max: 1}, true
+	}
+	return def.Type, true
+}
+
+// mapKey converts map key of index part to atom of column key type, so keys
+// of integer or uuid maps match too.
+func mapKey(columns map[string]ovshelper.Column, part indexPart) interface{} {
+	def, ok := columns[part.column]
+	if !ok || !def.Type.IsMap() {
+		return part.key
+	}
+	var key interface{}
+	var err error
+	switch def.Type.Key.Type {
+	case "integer":
+		key, err = strconv.ParseInt(part.key, 10, 64)
+	case "real":
+		key, err = strconv.ParseFloat(part.key, 64)
+	case "boolean":
+		key, err = strconv.ParseBool(part.key)
+	case "uuid":
+		key = ovshelper.UUID(part.key)
+	default:
+		key = part.key
+	}
+	if err != nil {
+		return part.key
+	}
+	return key
+}
+
+// partValue returns datum of index part in row, or nil if row has no value.
+// Values are decoded with column types when schema is known.
+func partValue(columns map[string]ovshelper.Column, row map[string]interface{}, part indexPart) (interface{}, error) {
+	raw, ok := row[part.column]
+	if !ok {
+		return nil, nil
+	}
+	var datum interface{}
+	var err error
+	if def, ok := columns[part.column]; ok {
+		datum, err = ovshelper.DecodeDatum(def.Type, raw)
+	}
+	if datum == nil || err != nil {
+		if datum, err = ovshelper.ParseDatum(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	if part.isKey {
+		m, ok := datum.(ovshelper.Map)
+		if !ok {
+			return nil, nil
+		}
+		val, ok := m[mapKey(columns, part)]
+		if !ok {
+			return nil, nil
+		}
+		return val, nil
+	}
+
+	// unset optional value
+	if set, ok := datum.(ovshelper.Set); ok && len(set) == 0 {
+		return nil, nil
+	}
+	return datum, nil
+}
+
+// rowKey returns index key of row, ok is false if row is not indexed.
+func rowKey(columns map[string]ovshelper.Column, parts []indexPart, row map[string]interface{}) (string, bool) {
+	keys := make([]string, len(parts))
+	for i, part := range parts {
+		datum, err := partValue(columns, row, part)
+		if err != nil || datum == nil {
+			return "", false
+		}
+		keys[i] = ovshelper.DatumKey(datum)
+	}
+	return strings.Join(keys, "\x00"), true
+}
+
+// valuesKey returns index key for lookup values given as Go values. Values
+// are converted to column types when schema is known, so for example plain
+// string matches uuid column.
+func valuesKey(columns map[string]ovshelper.Column, parts []indexPart, values []interface{}) (string, error) {
+	if len(values) != len(parts) {
+		return "", errors.New(fmt.Sprintf("index has %d parts, %d values given", len(parts), len(values)))
+	}
+	keys := make([]string, len(values))
+	for i, value := range values {
+		datum, err := ovshelper.ToDatum(value)
+		if err != nil {
+			return "", err
+		}
+		if t, ok := partType(columns, parts[i]); ok {
+			if typed, err := decodeValue(t, value); err == nil {
+				datum = typed
+			}
+		}
+		keys[i] = ovshelper.DatumKey(datum)
+	}
+	return strings.Join(keys, "\x00"), nil
+}
+
+// decodeValue converts Go value to datum of type t, see ovshelper.DecodeDatum.
+func decodeValue(t ovshelper.Type, value interface{}) (interface{}, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	dec := json.NewDecoder(bytes.NewReader(encoded))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return ovshelper.DecodeDatum(t, raw)
+}
+
+func (idx *index) add(uuid string, row map[string]interface{}) {
+	key, ok := rowKey(idx.columns, idx.parts, row)
+	if !ok {
+		return
+	}
+	if idx.keys[key] == nil {
+		idx.keys[key] = make(map[string]bool)
+	}
+	idx.keys[key][uuid] = true
+	idx.rowKeys[uuid] = key
+}
+
+func (idx *index) remove(uuid string) {
+	key, ok := idx.rowKeys[uuid]
+	if !ok {
+		return
+	}
+	delete(idx.keys[key], uuid)
+	if len(idx.keys[key]) == 0 {
+		delete(idx.keys, key)
+	}
+	delete(idx.rowKeys, uuid)
+}
+
+func (idx *index) lookup(key string) []string {
+	uuids := make([]string, 0, len(idx.keys[key]))
+	for uuid := range idx.keys[key] {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+	return uuids
+}
+
+// schemaIndexes returns unique indexes declared in database schema.
+func schemaIndexes(parsed *ovshelper.Schema) map[string][]string {
+	indexes := map[string][]string{}
+	if parsed == nil {
+		return indexes
+	}
+	for name, table := range parsed.Tables {
+		for _, i := range table.Indexes {
+			columns, _ := i.([]interface{})
+			parts := make([]string, 0, len(columns))
+			for _, column := range columns {
+				if s, ok := column.(string); ok {
+					parts = append(parts, s)
+				}
+			}
+			if len(parts) > 0 {
+				indexes[name] = append(indexes[name], strings.Join(parts, ","))
+			}
+		}
+	}
+	return indexes
+}
+
+// makeIndexes creates indexes for monitored tables from cache.Indexes and
+// schema. Schema indexes on columns which are not monitored are skipped.
+func (cache *Cache) makeIndexes(tables map[string][]string, schemaIndexes map[string][]string) {
+	cache.indexes = make(map[string]map[string]*index)
+	for table, columns := range tables {
+		cache.indexes[table] = make(map[string]*index)
+		for _, spec := range cache.Indexes[table] {
+			cache.indexes[table][spec] = newIndex(spec, false, tableColumns(cache.schemaDef, table))
+		}
+
+	schemaIndex:
+		for _, spec := range schemaIndexes[table] {
+			if columns != nil {
+				for _, part := range parseIndex(spec) {
+					if !containsString(columns, part.column) {
+						continue schemaIndex
+					}
+				}
+			}
+			if idx, ok := cache.indexes[table][spec]; ok {
+				idx.unique = true
+			} else {
+				cache.indexes[table][spec] = newIndex(spec, true, tableColumns(cache.schemaDef, table))
+			}
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (cache *Cache) indexRow(table string, uuid string, row map[string]interface{}) {
+	for _, idx := range cache.indexes[table] {
+		idx.remove(uuid)
+		if row != nil {
+			idx.add(uuid, row)
+		}
+	}
+}
+
+// Lookup returns sorted uuids of rows with given index values, one value for
+// each index part. Index is an index specification, see cache.Indexes. If
+// there is no such index, rows are searched one by one. Values and map keys
+// are converted to column types, so uuids can be given as plain strings.
+//
+//	cache.Lookup("Interface", "external_ids:iface-id", "vm1")
+//	cache.Lookup("Logical_Switch_Port", "name,type", "lsp1", "router")
+func (cache *Cache) Lookup(table string, spec string, values ...interface{}) ([]string, error) {
+	cache.RLock()
+	defer cache.RUnlock()
+
+	return cache.lookup(table, spec, values)
+}
+
+func (cache *Cache) lookup(table string, spec string, values []interface{}) ([]string, error) {
+	columns := tableColumns(cache.schemaDef, table)
+	if idx, ok := cache.indexes[table][spec]; ok {
+		key, err := valuesKey(columns, idx.parts, values)
+		if err != nil {
+			return nil, err
+		}
+		return idx.lookup(key), nil
+	}
+
+	parts := parseIndex(spec)
+	key, err := valuesKey(columns, parts, values)
+	if err != nil {
+		return nil, err
+	}
+	uuids := []string{}
+	for uuid, row := range cache.rows[table] {
+		if k, ok := rowKey(columns, parts, row); ok && k == key {
+			uuids = append(uuids, uuid)
+		}
+	}
+	sort.Strings(uuids)
+	return uuids, nil
+}
+
+// IndexConflict describes index value shared by several rows in an index
+// which should be unique.
+type IndexConflict struct {
+	Table string
+	Index string
+	Key   string // index values as used for lookup, parts separated by zero byte
+	UUIDs []string
+}
+
+// Conflicts returns values shared by several rows in single column indexes,
+// which are kept in Data with one row per value, and in unique indexes
+// declared in schema. For conflicting values Data refers to the row with the
+// lowest uuid.
+func (cache *Cache) Conflicts() []IndexConflict {
+	cache.RLock()
+	defer cache.RUnlock()
+
+	conflicts := []IndexConflict{}
+	for _, table := range ovshelper.SortedKeys(cache.indexes) {
+		for _, spec := range ovshelper.SortedKeys(cache.indexes[table]) {
+			idx := cache.indexes[table][spec]
+			if !idx.unique && !(isLegacyIndex(spec) && containsString(cache.Indexes[table], spec)) {
+				continue
+			}
+			for _, key := range ovshelper.SortedKeys(idx.keys) {
+				if len(idx.keys[key]) > 1 {
+					conflicts = append(conflicts, IndexConflict{
+						Table: table,
+						Index: spec,
+						Key:   key,
+						UUIDs: idx.lookup(key),
+					})
+				}
+			}
+		}
+	}
+	return conflicts
+}
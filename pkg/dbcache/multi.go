@@ -0,0 +1,174 @@
+package dbcache
+
+import (
+	"context"
+	"errors"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+	"sync"
+	"sync/atomic"
+)
+
+// MultiCache combines caches of several databases monitored on one
+// connection, for example Open_vSwitch and _Server, or OVN_Northbound and
+// OVN_Southbound through relay. Caches are updated one notification at a
+// time in order in which server sent them, so snapshot made of caches right
+// after any update is consistent across databases.
+type MultiCache struct {
+	sync.Mutex
+	caches  map[string]*Cache
+	current atomic.Pointer[MultiSnapshot]
+	changed chan struct{} // closed on each change, see WaitFor
+}
+
+// MultiSnapshot is an immutable version of all databases of MultiCache.
+type MultiSnapshot struct {
+	Version   uint64 // number of snapshots published by any of caches
+	databases map[string]*Snapshot
+}
+
+func NewMultiCache() *MultiCache {
+	return &MultiCache{caches: make(map[string]*Cache)}
+}
+
+// Add adds cache of database given by cache Schema, it can be started before
+// or after it is added. Each database can be added once.
+func (m *MultiCache) Add(cache *Cache) error {
+	// cache lock goes before MultiCache lock, as in publish
+	cache.Lock()
+	defer cache.Unlock()
+
+	if cache.onPublish != nil {
+		return errors.New("cache of " + cache.Schema + " is already combined")
+	}
+	m.Lock()
+	if _, ok := m.caches[cache.Schema]; ok {
+		m.Unlock()
+		return errors.New("database " + cache.Schema + " is already cached")
+	}
+	m.caches[cache.Schema] = cache
+	m.Unlock()
+
+	cache.onPublish = func(snapshot *Snapshot) {
+		m.publish(cache.Schema, snapshot)
+	}
+	m.publish(cache.Schema, cache.Snapshot())
+	return nil
+}
+
+// publish makes new snapshot with new snapshot of database.
+func (m *MultiCache) publish(database string, snapshot *Snapshot) {
+	m.Lock()
+	defer m.Unlock()
+
+	current := m.Snapshot()
+	next := &MultiSnapshot{
+		Version:   current.Version + 1,
+		databases: make(map[string]*Snapshot, len(current.databases)+1),
+	}
+	for name, s := range current.databases {
+		next.databases[name] = s
+	}
+	next.databases[database] = snapshot
+	m.current.Store(next)
+
+	if m.changed != nil {
+		close(m.changed)
+	}
+	m.changed = make(chan struct{})
+}
+
+// Database returns cache of database, nil if database is not cached.
+func (m *MultiCache) Database(name string) *Cache {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.caches[name]
+}
+
+// Databases returns names of cached databases in sorted order.
+func (m *MultiCache) Databases() []string {
+	m.Lock()
+	defer m.Unlock()
+
+	return ovshelper.SortedKeys(m.caches)
+}
+
+// Ready tells whether caches of all databases are ready.
+func (m *MultiCache) Ready() bool {
+	for _, name := range m.Databases() {
+		if !m.Database(name).Ready() {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshot returns current version of all databases, it does not lock caches.
+func (m *MultiCache) Snapshot() *MultiSnapshot {
+	if snapshot := m.current.Load(); snapshot != nil {
+		return snapshot
+	}
+	return &MultiSnapshot{databases: map[string]*Snapshot{}}
+}
+
+// Lookup works like Cache.Lookup on cache of database.
+func (m *MultiCache) Lookup(database string, table string, spec string, values ...interface{}) ([]string, error) {
+	cache := m.Database(database)
+	if cache == nil {
+		return nil, errors.New("database " + database + " is not cached")
+	}
+	return cache.Lookup(table, spec, values...)
+}
+
+// WaitFor blocks until predicate returns true for current snapshot while all
+// caches are ready. Returns ctx.Err() if context is done first.
+func (m *MultiCache) WaitFor(ctx context.Context, predicate func(*MultiSnapshot) bool) error {
+	for {
+		m.Lock()
+		if m.changed == nil {
+			m.changed = make(chan struct{})
+		}
+		changed := m.changed
+		snapshot := m.Snapshot()
+		m.Unlock()
+
+		if m.Ready() && predicate(snapshot) {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Database returns snapshot of database, it is empty if database is not
+// cached.
+func (s *MultiSnapshot) Database(name string) *Snapshot {
+	if snapshot, ok := s.databases[name]; ok {
+		return snapshot
+	}
+	return &Snapshot{Schema: name, rows: map[string]*rowTable{}}
+}
+
+// Row works like Snapshot.Row on snapshot of database.
+func (s *MultiSnapshot) Row(database string, table string, uuid string) map[string]interface{} {
+	return s.Database(database).Row(table, uuid)
+}
+
+// GetKeys works like Cache.GetKeys on snapshot of database.
+func (s *MultiSnapshot) GetKeys(database string, args ...string) []string {
+	return s.Database(database).GetKeys(args...)
+}
+
+// GetList works like Cache.GetList on snapshot of database.
+func (s *MultiSnapshot) GetList(database string, args ...string) []interface{} {
+	return s.Database(database).GetList(args...)
+}
+
+// GetMap works like Cache.GetMap on snapshot of database.
+func (s *MultiSnapshot) GetMap(database string, args ...string) map[string]interface{} {
+	return s.Database(database).GetMap(args...)
+}
@@ -0,0 +1,162 @@
+package dbcache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbmonitor"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// savedCache is the file format of Save and Load.
+type savedCache struct {
+	Schema        string                                       `json:"schema"`
+	SchemaVersion string                                       `json:"schema_version"`
+	LastTxnId     string                                       `json:"last_txn_id"`
+	Config        map[string]TableConfig                       `json:"config"`
+	Rows          map[string]map[string]map[string]interface{} `json:"rows"`
+}
+
+// Save writes cached rows and last transaction id to file, so cache can be
+// loaded with Load after restart. File is replaced atomically.
+func (cache *Cache) Save(path string) error {
+	cache.RLock()
+	saved := savedCache{
+		Schema:        cache.Schema,
+		SchemaVersion: cache.schemaVersion,
+		LastTxnId:     cache.lastTxnId,
+		Config:        cache.config,
+		Rows:          cache.rows,
+	}
+	encoded, err := json.Marshal(saved)
+	cache.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(encoded); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load fills cache with rows saved by Save. Loaded cache can be read, but it
+// is not ready until StartMonitor is called. With Method "monitor_cond_since"
+// StartMonitor asks server only for changes after saved transaction, if the
+// same tables, columns and conditions are monitored and schema version is the
+// same.
+// Otherwise all rows are fetched again.
+func (cache *Cache) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var saved savedCache
+	if err := decodeRaw(data, &saved); err != nil {
+		return err
+	}
+	if cache.Schema != "" && saved.Schema != cache.Schema {
+		return errors.New(fmt.Sprintf("file %s holds %s database, not %s", path, saved.Schema, cache.Schema))
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	if cache.ready {
+		return errors.New("cache is monitored, it can't be loaded")
+	}
+	for table, rows := range cache.rows {
+		for _, uuid := range sortedRowIds(rows) {
+			cache.recordChange(table, uuid, rows[uuid], nil)
+		}
+	}
+	if err := cache.restore(columnsOf(saved.Config), cache.schemaDef, saved.Rows, true); err != nil {
+		return err
+	}
+	cache.config = saved.Config
+	cache.schemaVersion = saved.SchemaVersion
+	cache.lastTxnId = saved.LastTxnId
+	cache.flushEvents()
+	cache.signal()
+	return nil
+}
+
+// resumable tells whether monitor for config can continue from lastTxnId.
+// Must be called with cache locked.
+func (cache *Cache) resumable(config map[string]TableConfig, schemaDef *ovshelper.Schema) bool {
+	if cache.lastTxnId == "" || cache.schemaVersion != schemaDef.Version || !sameMonitor(config, cache.config) {
+		return false
+	}
+	for table, c := range config {
+		if !sameConditions(c.Where, cache.config[table].Where) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameColumns(a []string, b []string) bool {
+	if (a == nil) != (b == nil) || len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// restore rebuilds cache structures from rows. Event handlers see rows as
+// added only if notify is set. Must be called with cache locked.
+func (cache *Cache) restore(tables map[string][]string, schemaDef *ovshelper.Schema, rows map[string]map[string]map[string]interface{}, notify bool) error {
+	var recorded int
+	if cache.events != nil {
+		recorded = len(cache.events.changes)
+	}
+
+	cache.Data = make(map[string]interface{})
+	cache.rows = make(map[string]map[string]map[string]interface{})
+	for table := range tables {
+		cache.rows[table] = make(map[string]map[string]interface{})
+		cache.initData(table)
+	}
+	cache.monitored = tables
+	cache.schemaDef = schemaDef
+	cache.makeIndexes(tables, schemaIndexes(schemaDef))
+	cache.rebuild = true
+
+	update := make(map[string]map[string]dbmonitor.RowUpdate, len(rows))
+	for table, tableRows := range rows {
+		update[table] = make(map[string]dbmonitor.RowUpdate, len(tableRows))
+		for uuid, row := range tableRows {
+			update[table][uuid] = dbmonitor.RowUpdate{New: row}
+		}
+	}
+	err := cache.apply(update)
+
+	if !notify && cache.events != nil {
+		cache.events.changes = cache.events.changes[:recorded]
+	}
+	return err
+}
@@ -0,0 +1,185 @@
+package dbcache
+
+import (
+	"sort"
+)
+
+const (
+	trieBits   = 5
+	trieFanout = 1 << trieBits
+	trieDepth  = 32 / trieBits // levels which have hash bits to split by
+	trieLeaf   = 16            // entries in leaf before it is split
+)
+
+// pmap is a persistent hash trie of values by string key. It is never
+// changed after it is built, changes make new version sharing all nodes but
+// the ones on paths to changed keys, so update costs are proportional to
+// trie depth and not to map size.
+type pmap[V any] struct {
+	root *pnode[V]
+	size int
+}
+
+// pnode is either inner node with children or leaf with entries.
+type pnode[V any] struct {
+	children *[trieFanout]*pnode[V]
+	entries  map[string]V
+}
+
+// rowTable keeps rows by uuid.
+type rowTable = pmap[map[string]interface{}]
+
+// hashKey is 32-bit FNV-1a hash of key.
+func hashKey(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h
+}
+
+func childIndex(h uint32, depth int) int {
+	return int(h>>(depth*trieBits)) & (trieFanout - 1)
+}
+
+func (m *pmap[V]) get(key string) (V, bool) {
+	var zero V
+	if m == nil {
+		return zero, false
+	}
+	h := hashKey(key)
+	n := m.root
+	for depth := 0; n != nil && n.children != nil; depth++ {
+		n = n.children[childIndex(h, depth)]
+	}
+	if n == nil {
+		return zero, false
+	}
+	v, ok := n.entries[key]
+	return v, ok
+}
+
+func (m *pmap[V]) len() int {
+	if m == nil {
+		return 0
+	}
+	return m.size
+}
+
+// ids returns sorted keys.
+func (m *pmap[V]) ids() []string {
+	ids := make([]string, 0, m.len())
+	if m == nil {
+		return ids
+	}
+	var walk func(n *pnode[V])
+	walk = func(n *pnode[V]) {
+		if n == nil {
+			return
+		}
+		if n.children != nil {
+			for _, child := range n.children {
+				walk(child)
+			}
+			return
+		}
+		for key := range n.entries {
+			ids = append(ids, key)
+		}
+	}
+	walk(m.root)
+	sort.Strings(ids)
+	return ids
+}
+
+// pmapBuilder makes new version of pmap. Each node is copied once, on first
+// change below it.
+type pmapBuilder[V any] struct {
+	m     pmap[V]
+	owned map[*pnode[V]]bool // nodes copied by this builder, changed in place
+}
+
+func newPmapBuilder[V any](m *pmap[V]) *pmapBuilder[V] {
+	b := &pmapBuilder[V]{owned: make(map[*pnode[V]]bool)}
+	if m != nil {
+		b.m = *m
+	}
+	return b
+}
+
+func newRowTableBuilder(t *rowTable) *pmapBuilder[map[string]interface{}] {
+	return newPmapBuilder(t)
+}
+
+// own returns node which can be changed, copy of n unless n was made by
+// builder.
+func (b *pmapBuilder[V]) own(n *pnode[V]) *pnode[V] {
+	if n != nil && b.owned[n] {
+		return n
+	}
+	copied := &pnode[V]{}
+	switch {
+	case n == nil:
+		copied.entries = make(map[string]V)
+	case n.children != nil:
+		children := *n.children
+		copied.children = &children
+	default:
+		copied.entries = make(map[string]V, len(n.entries)+1)
+		for key, v := range n.entries {
+			copied.entries[key] = v
+		}
+	}
+	b.owned[copied] = true
+	return copied
+}
+
+// leaf returns changeable leaf for key, with changeable path to it.
+func (b *pmapBuilder[V]) leaf(h uint32) (*pnode[V], int) {
+	b.m.root = b.own(b.m.root)
+	n := b.m.root
+	depth := 0
+	for ; n.children != nil; depth++ {
+		i := childIndex(h, depth)
+		n.children[i] = b.own(n.children[i])
+		n = n.children[i]
+	}
+	return n, depth
+}
+
+// set stores value, which must not be changed afterwards.
+func (b *pmapBuilder[V]) set(key string, v V) {
+	h := hashKey(key)
+	n, depth := b.leaf(h)
+	if _, ok := n.entries[key]; !ok {
+		b.m.size++
+	}
+	n.entries[key] = v
+
+	if len(n.entries) > trieLeaf && depth < trieDepth {
+		n.children = new([trieFanout]*pnode[V])
+		for k, val := range n.entries {
+			i := childIndex(hashKey(k), depth)
+			if n.children[i] == nil {
+				n.children[i] = b.own(nil)
+			}
+			n.children[i].entries[k] = val
+		}
+		n.entries = nil
+	}
+}
+
+func (b *pmapBuilder[V]) delete(key string) {
+	if _, ok := b.m.get(key); !ok {
+		return
+	}
+	n, _ := b.leaf(hashKey(key))
+	delete(n.entries, key)
+	b.m.size--
+}
+
+func (b *pmapBuilder[V]) build() *pmap[V] {
+	m := b.m
+	return &m
+}
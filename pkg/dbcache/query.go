@@ -0,0 +1,188 @@
+package dbcache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+	"sort"
+)
+
+// Query selects cached rows of table. Where and Columns have the same meaning
+// as in dbtransaction.Select, so a query and a select on server can be used
+// in place of each other.
+type Query struct {
+	Table string
+	Where [][]interface{} // RFC 7047 conditions, nil selects all rows
+	// Filter is an additional Go predicate, row values are datums of schema
+	// column types, see Cache.TypedRow.
+	Filter  func(uuid string, row map[string]interface{}) bool
+	Columns []string // nil selects all cached columns, "_uuid" can be selected as well
+	OrderBy string   // column to order by, rows are ordered by uuid by default
+	Desc    bool     // descending order
+	Limit   int      // maximal number of rows, 0 is no limit
+}
+
+// Query returns rows matching query in OVSDB notation, like rows returned
+// by select operation. Rows include "_uuid" if all columns are selected.
+//
+//	rows, err := snapshot.Query(dbcache.Query{
+//		Table:   "Interface",
+//		Where:   dbtransaction.Where(dbtransaction.Equal("type", "internal")),
+//		Columns: []string{"_uuid", "name"},
+//		OrderBy: "name",
+//		Limit:   10,
+//	})
+func (s *Snapshot) Query(q Query) ([]map[string]interface{}, error) {
+	if !s.HasTable(q.Table) {
+		return nil, errors.New(fmt.Sprintf("table %s is not cached", q.Table))
+	}
+	conditions, err := parseWhere(q.Where)
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		uuid string
+		row  map[string]interface{}
+		key  interface{}
+	}
+	var results []result
+	for _, uuid := range s.rows[q.Table].ids() {
+		row, _ := s.rows[q.Table].get(uuid)
+		ok, err := matchRow(uuid, row, conditions)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if q.Filter != nil {
+			typed, err := typedRow(s.schema, q.Table, row)
+			if err != nil {
+				return nil, err
+			}
+			if !q.Filter(uuid, typed) {
+				continue
+			}
+		}
+
+		r := result{uuid: uuid, row: row}
+		if q.OrderBy != "" {
+			if r.key, err = orderKey(uuid, row, q.OrderBy); err != nil {
+				return nil, err
+			}
+		}
+		results = append(results, r)
+	}
+
+	// rows are in uuid order, so equal keys keep it
+	if q.OrderBy != "" {
+		sort.SliceStable(results, func(i, j int) bool {
+			if q.Desc {
+				return lessDatum(results[j].key, results[i].key)
+			}
+			return lessDatum(results[i].key, results[j].key)
+		})
+	} else if q.Desc {
+		for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+			results[i], results[j] = results[j], results[i]
+		}
+	}
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+
+	rows := make([]map[string]interface{}, len(results))
+	for i, r := range results {
+		if rows[i], err = project(r.uuid, r.row, q.Columns); err != nil {
+			return nil, err
+		}
+	}
+	return rows, nil
+}
+
+// Query works like Snapshot.Query on current snapshot.
+func (cache *Cache) Query(q Query) ([]map[string]interface{}, error) {
+	return cache.Snapshot().Query(q)
+}
+
+func orderKey(uuid string, row map[string]interface{}, column string) (interface{}, error) {
+	if column == "_uuid" {
+		return uuid, nil
+	}
+	raw, ok := row[column]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("column %s is not cached", column))
+	}
+	datum, err := ovshelper.ParseDatum(raw)
+	if err != nil {
+		return nil, err
+	}
+	// optional values are ordered by their value, unset ones go first
+	if set, ok := datum.(ovshelper.Set); ok && len(set) == 1 {
+		datum = set[0]
+	}
+	return datum, nil
+}
+
+// lessDatum orders numbers, strings and booleans by value. Other datums are
+// ordered after atoms by their keys.
+func lessDatum(a interface{}, b interface{}) bool {
+	rank := func(datum interface{}) int {
+		switch datum.(type) {
+		case ovshelper.Set, ovshelper.Map:
+			return 0
+		case bool:
+			return 1
+		case int64, float64:
+			return 2
+		case string, ovshelper.UUID:
+			return 3
+		}
+		return 4
+	}
+	if rank(a) != rank(b) {
+		return rank(a) < rank(b)
+	}
+	switch x := a.(type) {
+	case bool:
+		return !x && b.(bool)
+	case int64, float64:
+		return toFloat(a) < toFloat(b)
+	case string:
+		return x < fmt.Sprint(b)
+	case ovshelper.UUID:
+		return string(x) < fmt.Sprint(b)
+	}
+	return ovshelper.DatumKey(a) < ovshelper.DatumKey(b)
+}
+
+func toFloat(number interface{}) float64 {
+	if i, ok := number.(int64); ok {
+		return float64(i)
+	}
+	return number.(float64)
+}
+
+// project returns selected columns of row, "_uuid" is returned in OVSDB
+// notation like by server.
+func project(uuid string, row map[string]interface{}, columns []string) (map[string]interface{}, error) {
+	if columns == nil {
+		ret := copyRow(row)
+		ret["_uuid"] = []interface{}{"uuid", uuid}
+		return ret, nil
+	}
+	ret := make(map[string]interface{}, len(columns))
+	for _, column := range columns {
+		if column == "_uuid" {
+			ret[column] = []interface{}{"uuid", uuid}
+			continue
+		}
+		value, ok := row[column]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("column %s is not cached", column))
+		}
+		ret[column] = value
+	}
+	return ret, nil
+}
@@ -0,0 +1,283 @@
+package dbcache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+	"sort"
+)
+
+// Referrer is a row column which refers to a row.
+type Referrer struct {
+	Table  string
+	Column string
+	UUID   string
+}
+
+// RefRow is a row found by following references. Row is in OVSDB notation,
+// like rows returned by Snapshot.Row.
+type RefRow struct {
+	Table string
+	UUID  string
+	Row   map[string]interface{}
+}
+
+type reference struct {
+	Referrer
+	refTable string
+	refUUID  string
+}
+
+// columnRefs returns uuids of rows referred by column value, by referred
+// table. Value is in OVSDB notation or normalized like in Data.
+func columnRefs(def ovshelper.Column, value interface{}) map[string][]string {
+	refs := map[string][]string{}
+	add := func(table string, atom interface{}) {
+		if uuid, ok := atom.(ovshelper.UUID); ok && table != "" {
+			refs[table] = append(refs[table], string(uuid))
+		}
+	}
+
+	datum, err := ovshelper.DecodeDatum(def.Type, value)
+	if err != nil {
+		// normalized values hold uuids as strings, which can only be
+		// told apart from other strings by their form
+		table := def.Type.Key.RefTable
+		if table == "" {
+			table = def.Type.Value.RefTable
+		}
+		if table != "" {
+			collectUUIDs(value, func(uuid string) {
+				refs[table] = append(refs[table], uuid)
+			})
+		}
+		return refs
+	}
+
+	switch d := datum.(type) {
+	case ovshelper.Set:
+		for _, atom := range d {
+			add(def.Type.Key.RefTable, atom)
+		}
+	case ovshelper.Map:
+		for key, val := range d {
+			add(def.Type.Key.RefTable, key)
+			add(def.Type.Value.RefTable, val)
+		}
+	default:
+		add(def.Type.Key.RefTable, d)
+	}
+	return refs
+}
+
+func collectUUIDs(value interface{}, found func(string)) {
+	switch v := value.(type) {
+	case string:
+		if ovshelper.IsUUID(v) {
+			found(v)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectUUIDs(item, found)
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			collectUUIDs(key, found)
+			collectUUIDs(item, found)
+		}
+	}
+}
+
+// rowRefs returns references of row to other rows.
+func rowRefs(schema *ovshelper.Schema, table string, uuid string, row map[string]interface{}) []reference {
+	if row == nil || schema == nil {
+		return nil
+	}
+	var refs []reference
+	for column, def := range schema.Tables[table].Columns {
+		value, ok := row[column]
+		if !ok || (def.Type.Key.RefTable == "" && def.Type.Value.RefTable == "") {
+			continue
+		}
+		for refTable, uuids := range columnRefs(def, value) {
+			for _, refUUID := range uuids {
+				refs = append(refs, reference{Referrer: Referrer{Table: table, Column: column, UUID: uuid}, refTable: refTable, refUUID: refUUID})
+			}
+		}
+	}
+	return refs
+}
+
+func referrerLess(a Referrer, b Referrer) bool {
+	if a.Table != b.Table {
+		return a.Table < b.Table
+	}
+	if a.Column != b.Column {
+		return a.Column < b.Column
+	}
+	return a.UUID < b.UUID
+}
+
+// referrersBuilder makes referrers of new snapshot from referrers of previous
+// one and changed rows.
+type referrersBuilder struct {
+	schema *ovshelper.Schema
+	old    map[string]*pmap[[]Referrer]
+	tables map[string]*pmapBuilder[[]Referrer]
+}
+
+func newReferrersBuilder(schema *ovshelper.Schema, old map[string]*pmap[[]Referrer]) *referrersBuilder {
+	return &referrersBuilder{
+		schema: schema,
+		old:    old,
+		tables: make(map[string]*pmapBuilder[[]Referrer]),
+	}
+}
+
+func (b *referrersBuilder) table(refTable string) *pmapBuilder[[]Referrer] {
+	if t, ok := b.tables[refTable]; ok {
+		return t
+	}
+	t := newPmapBuilder(b.old[refTable])
+	b.tables[refTable] = t
+	return t
+}
+
+// update replaces references of row, oldRow is row before change, nil for
+// inserted row, and row is nil for deleted one.
+func (b *referrersBuilder) update(table string, uuid string, oldRow map[string]interface{}, row map[string]interface{}) {
+	for _, ref := range rowRefs(b.schema, table, uuid, oldRow) {
+		t := b.table(ref.refTable)
+		referrers, _ := t.m.get(ref.refUUID)
+		ret := make([]Referrer, 0, len(referrers))
+		for _, referrer := range referrers {
+			if referrer != ref.Referrer {
+				ret = append(ret, referrer)
+			}
+		}
+		if len(ret) == 0 {
+			t.delete(ref.refUUID)
+		} else {
+			t.set(ref.refUUID, ret)
+		}
+	}
+	for _, ref := range rowRefs(b.schema, table, uuid, row) {
+		t := b.table(ref.refTable)
+		referrers, _ := t.m.get(ref.refUUID)
+		i := sort.Search(len(referrers), func(i int) bool {
+			return !referrerLess(referrers[i], ref.Referrer)
+		})
+		if i < len(referrers) && referrers[i] == ref.Referrer {
+			continue
+		}
+		ret := make([]Referrer, 0, len(referrers)+1)
+		ret = append(ret, referrers[:i]...)
+		ret = append(ret, ref.Referrer)
+		t.set(ref.refUUID, append(ret, referrers[i:]...))
+	}
+}
+
+func (b *referrersBuilder) build() map[string]*pmap[[]Referrer] {
+	ret := make(map[string]*pmap[[]Referrer], len(b.old)+len(b.tables))
+	for refTable, referrers := range b.old {
+		ret[refTable] = referrers
+	}
+	for refTable, t := range b.tables {
+		ret[refTable] = t.build()
+	}
+	return ret
+}
+
+// Referrers returns cached rows referring to row, sorted by table, column and
+// uuid. Rows can refer to rows which are not cached.
+//
+//	cache.Referrers("Port", portId) // [{Bridge ports <bridge uuid>}]
+func (cache *Cache) Referrers(table string, uuid string) []Referrer {
+	return cache.Snapshot().Referrers(table, uuid)
+}
+
+// Referrers works like Cache.Referrers on snapshot rows.
+func (s *Snapshot) Referrers(table string, uuid string) []Referrer {
+	referrers, _ := s.referrers[table].get(uuid)
+	return append([]Referrer{}, referrers...)
+}
+
+// Resolve returns rows referred by column of row, sorted by uuid. Row of
+// table can be in OVSDB notation or normalized like in Data. References to
+// rows which are not cached are skipped.
+//
+//	ports, err := cache.Resolve("Bridge", cache.GetMap("Bridge", "name", "br0"), "ports")
+func (cache *Cache) Resolve(table string, row map[string]interface{}, column string) ([]RefRow, error) {
+	return cache.Snapshot().Resolve(table, row, column)
+}
+
+// Follow works like Snapshot.Follow on current snapshot.
+func (cache *Cache) Follow(table string, uuid string, columns ...string) ([]RefRow, error) {
+	return cache.Snapshot().Follow(table, uuid, columns...)
+}
+
+// Resolve works like Cache.Resolve on snapshot rows.
+func (s *Snapshot) Resolve(table string, row map[string]interface{}, column string) ([]RefRow, error) {
+	if s.schema == nil {
+		return nil, errors.New("schema is not known")
+	}
+	def, ok := s.schema.Tables[table].Columns[column]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unknown column %s.%s", table, column))
+	}
+	if def.Type.Key.RefTable == "" && def.Type.Value.RefTable == "" {
+		return nil, errors.New(fmt.Sprintf("column %s.%s is not a reference", table, column))
+	}
+
+	rows := []RefRow{}
+	seen := map[string]bool{}
+	for refTable, uuids := range columnRefs(def, row[column]) {
+		for _, uuid := range uuids {
+			refRow := s.Row(refTable, uuid)
+			if refRow == nil || seen[refTable+uuid] {
+				continue
+			}
+			seen[refTable+uuid] = true
+			rows = append(rows, RefRow{Table: refTable, UUID: uuid, Row: refRow})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].UUID < rows[j].UUID
+	})
+	return rows, nil
+}
+
+// Follow follows references from row through columns, each column is a
+// reference column of the table reached by previous one. Rows reached by
+// the last column are returned once, sorted by uuid.
+//
+//	// interfaces of bridge
+//	interfaces, err := snapshot.Follow("Bridge", bridgeId, "ports", "interfaces")
+func (s *Snapshot) Follow(table string, uuid string, columns ...string) ([]RefRow, error) {
+	row := s.Row(table, uuid)
+	if row == nil {
+		return nil, errors.New(fmt.Sprintf("%s row %s is not cached", table, uuid))
+	}
+	current := []RefRow{{Table: table, UUID: uuid, Row: row}}
+	for _, column := range columns {
+		next := []RefRow{}
+		seen := map[string]bool{}
+		for _, r := range current {
+			rows, err := s.Resolve(r.Table, r.Row, column)
+			if err != nil {
+				return nil, err
+			}
+			for _, refRow := range rows {
+				if !seen[refRow.Table+refRow.UUID] {
+					seen[refRow.Table+refRow.UUID] = true
+					next = append(next, refRow)
+				}
+			}
+		}
+		sort.Slice(next, func(i, j int) bool {
+			return next[i].UUID < next[j].UUID
+		})
+		current = next
+	}
+	return current, nil
+}
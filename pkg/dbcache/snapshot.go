@@ -0,0 +1,299 @@
+package dbcache
+
+import (
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+	"sort"
+)
+
+// Snapshot is an immutable version of cache data. Monitor updates make new
+// snapshots sharing unchanged data with previous ones, so snapshots are
+// cheap and can be read without locks. A series of reads from one snapshot
+// is consistent.
+type Snapshot struct {
+	Schema    string
+	Version   uint64 // number of monitor updates applied, including initial one
+	rows      map[string]*rowTable
+	legacy    map[string]map[string]*pmap[[]string] // legacy[table][index][value], sorted uuids of rows in single column indexes of Data
+	referrers map[string]*pmap[[]Referrer]          // referrers[refTable][refUUID], sorted rows referring to row
+	schema    *ovshelper.Schema
+	where     map[string][][]interface{} // conditions of tables cached with Where, see Conditions
+}
+
+// Snapshot returns current cache version, it does not lock cache.
+func (cache *Cache) Snapshot() *Snapshot {
+	if snapshot := cache.current.Load(); snapshot != nil {
+		return snapshot
+	}
+	return &Snapshot{Schema: cache.Schema, rows: map[string]*rowTable{}}
+}
+
+// Conditions returns Where conditions which selected cached rows of table,
+// see TableConfig. Monitor conditions select rows matching any of them. It is
+// nil when all rows of table are cached.
+func (s *Snapshot) Conditions(table string) [][]interface{} {
+	return s.where[table]
+}
+
+// Version returns version of current snapshot.
+func (cache *Cache) Version() uint64 {
+	return cache.Snapshot().Version
+}
+
+// publish makes new snapshot from the current one with changed rows of
+// tables, or from all rows after cache was reset. Must be called with cache
+// locked.
+func (cache *Cache) publish(changed map[string]map[string]bool) {
+	current := cache.Snapshot()
+	snapshot := &Snapshot{
+		Schema:  cache.Schema,
+		Version: current.Version + 1,
+		rows:    make(map[string]*rowTable, len(cache.rows)),
+		legacy:  make(map[string]map[string]*pmap[[]string], len(cache.rows)),
+		schema:  cache.schemaDef,
+		where:   make(map[string][][]interface{}),
+	}
+	for table, c := range cache.config {
+		if c.Where != nil {
+			snapshot.where[table] = c.Where
+		}
+	}
+	rebuild := cache.rebuild
+	cache.rebuild = false
+	referrers := newReferrersBuilder(cache.schemaDef, current.referrers)
+	if rebuild {
+		referrers = newReferrersBuilder(cache.schemaDef, nil)
+	}
+	for table := range cache.rows {
+		indexes := cache.legacyIndexes(table)
+		uuids, ok := changed[table]
+		if !rebuild && !ok && current.rows[table] != nil {
+			snapshot.rows[table] = current.rows[table]
+			snapshot.legacy[table] = current.legacy[table]
+			continue
+		}
+
+		oldRows := current.rows[table]
+		oldLegacy := current.legacy[table]
+		if rebuild {
+			oldRows, oldLegacy = nil, nil
+			uuids = make(map[string]bool, len(cache.rows[table]))
+			for uuid := range cache.rows[table] {
+				uuids[uuid] = true
+			}
+		}
+
+		b := newRowTableBuilder(oldRows)
+		legacy := make(map[string]*pmapBuilder[[]string], len(indexes))
+		for _, index := range indexes {
+			legacy[index] = newPmapBuilder(oldLegacy[index])
+		}
+		for uuid := range uuids {
+			oldRow, _ := oldRows.get(uuid)
+			row, ok := cache.rows[table][uuid]
+			if ok {
+				b.set(uuid, row)
+			} else {
+				b.delete(uuid)
+			}
+			referrers.update(table, uuid, oldRow, row)
+			for _, index := range indexes {
+				oldValue, hadValue := legacyValue(oldRow, index)
+				value, hasValue := legacyValue(row, index)
+				if hadValue == hasValue && oldValue == value {
+					continue
+				}
+				if hadValue {
+					ids, _ := legacy[index].m.get(oldValue)
+					if ids = removeId(ids, uuid); len(ids) == 0 {
+						legacy[index].delete(oldValue)
+					} else {
+						legacy[index].set(oldValue, ids)
+					}
+				}
+				if hasValue {
+					ids, _ := legacy[index].m.get(value)
+					legacy[index].set(value, addId(ids, uuid))
+				}
+			}
+		}
+		snapshot.rows[table] = b.build()
+		snapshot.legacy[table] = make(map[string]*pmap[[]string], len(indexes))
+		for _, index := range indexes {
+			snapshot.legacy[table][index] = legacy[index].build()
+		}
+	}
+	snapshot.referrers = referrers.build()
+	cache.current.Store(snapshot)
+	if cache.onPublish != nil {
+		cache.onPublish(snapshot)
+	}
+}
+
+func copyRow(row map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{}, len(row))
+	for column, val := range row {
+		ret[column] = val
+	}
+	return ret
+}
+
+// legacyValue returns value of row in single column index, only string values
+// are kept in Data.
+func legacyValue(row map[string]interface{}, index string) (string, bool) {
+	raw, ok := row[index]
+	if !ok {
+		return "", false
+	}
+	value, ok := normalize(raw).(string)
+	return value, ok
+}
+
+// addId returns sorted uuids with uuid added, ids are not changed.
+func addId(ids []string, uuid string) []string {
+	i := sort.SearchStrings(ids, uuid)
+	if i < len(ids) && ids[i] == uuid {
+		return ids
+	}
+	ret := make([]string, 0, len(ids)+1)
+	ret = append(ret, ids[:i]...)
+	ret = append(ret, uuid)
+	return append(ret, ids[i:]...)
+}
+
+// removeId returns sorted uuids without uuid, ids are not changed.
+func removeId(ids []string, uuid string) []string {
+	ret := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id != uuid {
+			ret = append(ret, id)
+		}
+	}
+	return ret
+}
+
+// legacyRow returns row in Data form.
+func legacyRow(uuid string, raw map[string]interface{}) map[string]interface{} {
+	row := normalizeMap(raw)
+	row["uuid"] = uuid
+	return row
+}
+
+// indexData returns Data[table][index] built from snapshot rows. Values
+// shared by several rows refer to the lowest uuid like in cache.
+func (s *Snapshot) indexData(table string, index string) map[string]interface{} {
+	ret := map[string]interface{}{}
+	rows := s.rows[table]
+	if index == "uuid" {
+		for _, uuid := range rows.ids() {
+			raw, _ := rows.get(uuid)
+			ret[uuid] = legacyRow(uuid, raw)
+		}
+		return ret
+	}
+	values, ok := s.legacy[table][index]
+	if !ok {
+		return ret
+	}
+	for _, value := range values.ids() {
+		uuids, _ := values.get(value)
+		raw, _ := rows.get(uuids[0])
+		ret[value] = legacyRow(uuids[0], raw)
+	}
+	return ret
+}
+
+// tableData returns Data[table] built from snapshot rows.
+func (s *Snapshot) tableData(table string) map[string]interface{} {
+	ret := map[string]interface{}{"uuid": s.indexData(table, "uuid")}
+	for index := range s.legacy[table] {
+		ret[index] = s.indexData(table, index)
+	}
+	return ret
+}
+
+// data returns value at path of Cache.Data structure. Only the part which
+// path refers to is built from snapshot rows, so reads of single rows do not
+// depend on database size.
+func (s *Snapshot) data(args ...string) interface{} {
+	if len(args) == 0 {
+		ret := make(map[string]interface{}, len(s.rows))
+		for table := range s.rows {
+			ret[table] = s.tableData(table)
+		}
+		return ret
+	}
+
+	table := args[0]
+	if _, ok := s.rows[table]; !ok {
+		return map[string]interface{}{}
+	}
+	switch len(args) {
+	case 1:
+		return s.tableData(table)
+	case 2:
+		return s.indexData(table, args[1])
+	}
+
+	index, uuid := args[1], args[2]
+	if index != "uuid" {
+		uuids, _ := s.legacy[table][index].get(args[2])
+		if len(uuids) == 0 {
+			return map[string]interface{}{}
+		}
+		uuid = uuids[0]
+	}
+	raw, ok := s.rows[table].get(uuid)
+	if !ok {
+		return map[string]interface{}{}
+	}
+	return getData(legacyRow(uuid, raw), args[3:]...)
+}
+
+// GetKeys works like Cache.GetKeys on snapshot data.
+func (s *Snapshot) GetKeys(args ...string) []string {
+	if len(args) == 0 {
+		return ovshelper.SortedKeys(s.rows)
+	}
+	if _, ok := s.rows[args[0]]; ok {
+		switch {
+		case len(args) == 1:
+			return append([]string{"uuid"}, ovshelper.SortedKeys(s.legacy[args[0]])...)
+		case len(args) == 2 && args[1] == "uuid":
+			return s.rows[args[0]].ids()
+		case len(args) == 2:
+			return s.legacy[args[0]][args[1]].ids()
+		}
+	}
+	return getKeys(s.data(args...).(map[string]interface{}))
+}
+
+// GetList works like Cache.GetList on snapshot data.
+func (s *Snapshot) GetList(args ...string) []interface{} {
+	return getList(s.data(args...).(map[string]interface{}))
+}
+
+// GetMap works like Cache.GetMap on snapshot data.
+func (s *Snapshot) GetMap(args ...string) map[string]interface{} {
+	return getMap(s.data(args...).(map[string]interface{}))
+}
+
+// Row returns row in OVSDB notation, as received from server, so values can
+// be used in transactions as they are. Returns nil if row is not cached.
+func (s *Snapshot) Row(table string, uuid string) map[string]interface{} {
+	row, ok := s.rows[table].get(uuid)
+	if !ok {
+		return nil
+	}
+	return copyRow(row)
+}
+
+// HasTable tells whether table is cached.
+func (s *Snapshot) HasTable(table string) bool {
+	_, ok := s.rows[table]
+	return ok
+}
+
+// RowIds returns sorted uuids of all cached rows in table.
+func (s *Snapshot) RowIds(table string) []string {
+	return s.rows[table].ids()
+}
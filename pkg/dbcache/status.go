@@ -0,0 +1,155 @@
+package dbcache
+
+import (
+	"context"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbmonitor"
+	"time"
+)
+
+type closeNotifier interface {
+	AddCloseCallback(id string, callback func())
+}
+
+type closeCallbackRemover interface {
+	RemoveCloseCallback(id string)
+}
+
+func (cache *Cache) callbackId() string {
+	if cache.ID != "" {
+		return cache.ID
+	}
+	return fmt.Sprintf("cache-%p", cache)
+}
+
+// disconnected is called when connection is closed. Monitor is gone with the
+// connection, so cache is stale until StartMonitor is called again.
+func (cache *Cache) disconnected() {
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.ready = false
+	cache.synced = false
+	cache.signal()
+}
+
+// watchMonitor makes cache stale when its monitor ends, for example when
+// server cancels it. Monitors replaced by Reconfigure or Start are ignored.
+func (cache *Cache) watchMonitor(monitor *dbmonitor.Monitor) {
+	<-monitor.Done()
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	if cache.monitor == monitor {
+		cache.ready = false
+		cache.synced = false
+		cache.signal()
+	}
+}
+
+// Stop cancels monitor of cache. Cached rows are kept, but cache is not
+// ready anymore, StartMonitor can start it again. Error of server is
+// returned.
+func (cache *Cache) Stop() error {
+	cache.Lock()
+	monitor, next := cache.monitor, cache.next
+	cache.monitor = nil
+	cache.next = nil
+	cache.pending = nil
+	cache.ready = false
+	cache.synced = false
+	cache.signal()
+	cache.Unlock()
+
+	if remover, ok := cache.OVSDB.(closeCallbackRemover); ok {
+		remover.RemoveCloseCallback(cache.callbackId())
+	}
+	if next != nil {
+		next.Stop()
+	}
+	if monitor != nil {
+		return monitor.Stop()
+	}
+	return nil
+}
+
+// signal wakes up WaitFor callers. Must be called with cache locked.
+func (cache *Cache) signal() {
+	if cache.changed != nil {
+		close(cache.changed)
+	}
+	cache.changed = make(chan struct{})
+}
+
+// Ready tells whether cache holds initial rows and receives updates. It is
+// false before StartMonitor returns, after connection is lost and after
+// server cancels monitor.
+func (cache *Cache) Ready() bool {
+	cache.RLock()
+	defer cache.RUnlock()
+
+	return cache.ready
+}
+
+// Generation is incremented each time cache is filled by StartMonitor, so
+// callers can detect that cache was refilled, for example after reconnect.
+func (cache *Cache) Generation() uint64 {
+	cache.RLock()
+	defer cache.RUnlock()
+
+	return cache.generation
+}
+
+// LastUpdate returns time when last update from server was applied.
+func (cache *Cache) LastUpdate() time.Time {
+	cache.RLock()
+	defer cache.RUnlock()
+
+	return cache.lastUpdate
+}
+
+// LastTxnId returns id of last transaction seen by cache. It is only known
+// when Method is "monitor_cond_since".
+func (cache *Cache) LastTxnId() string {
+	cache.RLock()
+	defer cache.RUnlock()
+
+	return cache.lastTxnId
+}
+
+// WaitFor blocks until predicate returns true for current snapshot of ready
+// cache. Predicate is called after each update. Returns ctx.Err() if context
+// is done first.
+//
+//	err := cache.WaitFor(ctx, func(s *dbcache.Snapshot) bool {
+//		return s.Row("Bridge", uuid) != nil
+//	})
+func (cache *Cache) WaitFor(ctx context.Context, predicate func(*Snapshot) bool) error {
+	for {
+		cache.RLock()
+		changed := cache.changed
+		ready := cache.ready
+		snapshot := cache.Snapshot()
+		cache.RUnlock()
+		if changed == nil {
+			// nothing was signaled yet, channel is made once
+			cache.Lock()
+			if cache.changed == nil {
+				cache.changed = make(chan struct{})
+			}
+			cache.Unlock()
+			continue
+		}
+
+		if ready && predicate(snapshot) {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
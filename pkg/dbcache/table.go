@@ -0,0 +1,115 @@
+package dbcache
+
+import (
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+	"sort"
+)
+
+// Table gives typed access to one cached table. Rows are decoded to model
+// struct T with ovshelper.DecodeRow, so T fields are mapped to columns with
+// `ovsdb` or `json` tags:
+//
+//	type Bridge struct {
+//		UUID  string            `ovsdb:"_uuid"`
+//		Name  string            `ovsdb:"name"`
+//		Ports []string          `ovsdb:"ports"`
+//		Ids   map[string]string `ovsdb:"external_ids"`
+//	}
+//
+//	bridges := dbcache.NewTable[Bridge](cache, "Bridge")
+//	br, err := bridges.Lookup("name", "br0")
+//
+// Table reads cache on each call, it is updated by monitor as before.
+type Table[T any] struct {
+	cache *Cache
+	name  string
+}
+
+// NewTable returns typed view of cached table.
+func NewTable[T any](cache *Cache, table string) *Table[T] {
+	return &Table[T]{cache: cache, name: table}
+}
+
+func (t *Table[T]) decode(uuid string, row map[string]interface{}) (*T, error) {
+	model := new(T)
+	if err := ovshelper.DecodeRow(uuid, row, model); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// Get returns row with given uuid, or nil if row is not cached.
+func (t *Table[T]) Get(uuid string) (*T, error) {
+	t.cache.RLock()
+	defer t.cache.RUnlock()
+
+	row, ok := t.cache.rows[t.name][uuid]
+	if !ok {
+		return nil, nil
+	}
+	return t.decode(uuid, row)
+}
+
+// List returns all rows sorted by uuid.
+func (t *Table[T]) List() ([]T, error) {
+	return t.Where(nil)
+}
+
+// Lookup returns first row, by uuid, with given index values, or nil if
+// there is none. See Cache.Lookup for index specification.
+func (t *Table[T]) Lookup(index string, values ...interface{}) (*T, error) {
+	list, err := t.LookupAll(index, values...)
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+// LookupAll returns all rows with given index values sorted by uuid.
+func (t *Table[T]) LookupAll(index string, values ...interface{}) ([]T, error) {
+	t.cache.RLock()
+	defer t.cache.RUnlock()
+
+	uuids, err := t.cache.lookup(t.name, index, values)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]T, 0, len(uuids))
+	for _, uuid := range uuids {
+		model, err := t.decode(uuid, t.cache.rows[t.name][uuid])
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *model)
+	}
+	return list, nil
+}
+
+// Where returns rows for which predicate returns true, sorted by uuid. Nil
+// predicate matches all rows.
+func (t *Table[T]) Where(predicate func(*T) bool) ([]T, error) {
+	t.cache.RLock()
+	defer t.cache.RUnlock()
+
+	rows := t.cache.rows[t.name]
+	list := []T{}
+	for _, uuid := range sortedRowIds(rows) {
+		model, err := t.decode(uuid, rows[uuid])
+		if err != nil {
+			return nil, err
+		}
+		if predicate == nil || predicate(model) {
+			list = append(list, *model)
+		}
+	}
+	return list, nil
+}
+
+func sortedRowIds(rows map[string]map[string]interface{}) []string {
+	ids := make([]string, 0, len(rows))
+	for uuid := range rows {
+		ids = append(ids, uuid)
+	}
+	sort.Strings(ids)
+	return ids
+}
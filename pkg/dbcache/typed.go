@@ -0,0 +1,74 @@
+package dbcache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+)
+
+type schemaProvider interface {
+	GetParsedSchema(string) (*ovshelper.Schema, error)
+}
+
+// fetchSchema returns parsed database schema.
+func (cache *Cache) fetchSchema(schema string) (*ovshelper.Schema, error) {
+	if provider, ok := cache.OVSDB.(schemaProvider); ok {
+		return provider.GetParsedSchema(schema)
+	}
+
+	response, err := cache.OVSDB.Call("get_schema", []string{schema}, nil)
+	if err != nil {
+		return nil, err
+	}
+	parsed := new(ovshelper.Schema)
+	if err := json.Unmarshal(response, parsed); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// typedRow converts row columns to datums of schema column types.
+func typedRow(schema *ovshelper.Schema, table string, row map[string]interface{}) (map[string]interface{}, error) {
+	if row == nil {
+		return nil, nil
+	}
+
+	var columns map[string]ovshelper.Column
+	if schema != nil {
+		columns = schema.Tables[table].Columns
+	}
+
+	ret := make(map[string]interface{}, len(row))
+	for column, raw := range row {
+		var datum interface{}
+		var err error
+		if def, ok := columns[column]; ok {
+			datum, err = ovshelper.DecodeDatum(def.Type, raw)
+		} else {
+			datum, err = ovshelper.ParseDatum(raw)
+		}
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s.%s: %s", table, column, err.Error()))
+		}
+		ret[column] = datum
+	}
+	return ret, nil
+}
+
+// TypedRow returns row with values of column types declared in schema, see
+// ovshelper.DecodeDatum. Unlike Data, sets of any atom type, empty optional
+// values and references are kept as they are in database, and values can be
+// used in transactions. Returns nil if row is not cached.
+func (cache *Cache) TypedRow(table string, uuid string) (map[string]interface{}, error) {
+	cache.RLock()
+	defer cache.RUnlock()
+
+	return typedRow(cache.schemaDef, table, cache.rows[table][uuid])
+}
+
+// TypedRow works like Cache.TypedRow on snapshot data.
+func (s *Snapshot) TypedRow(table string, uuid string) (map[string]interface{}, error) {
+	row, _ := s.rows[table].get(uuid)
+	return typedRow(s.schema, table, row)
+}
@@ -0,0 +1,126 @@
+package dbcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbmonitor"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+)
+
+func decodeRaw(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // keep integers exact in raw rows
+	return dec.Decode(v)
+}
+
+// toRaw converts datum to OVSDB notation as decoded from server messages.
+func toRaw(datum interface{}) (interface{}, error) {
+	encoded, err := json.Marshal(datum)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	err = decodeRaw(encoded, &raw)
+	return raw, err
+}
+
+// columnType returns schema type of column.
+func (cache *Cache) columnType(table string, column string) (ovshelper.Type, error) {
+	if cache.schemaDef != nil {
+		if def, ok := cache.schemaDef.Tables[table].Columns[column]; ok {
+			return def.Type, nil
+		}
+	}
+	return ovshelper.Type{}, errors.New(fmt.Sprintf("unknown column %s.%s", table, column))
+}
+
+// withDefaults adds monitored columns missing in row with their default
+// values. Update2 notifications leave out columns with default values.
+func (cache *Cache) withDefaults(table string, row map[string]interface{}) (map[string]interface{}, error) {
+	columns := cache.monitored[table]
+	if columns == nil && cache.schemaDef != nil {
+		for column := range cache.schemaDef.Tables[table].Columns {
+			columns = append(columns, column)
+		}
+	}
+
+	ret := copyRow(row)
+	for _, column := range columns {
+		if _, ok := ret[column]; ok {
+			continue
+		}
+		t, err := cache.columnType(table, column)
+		if err != nil {
+			return nil, err
+		}
+		if ret[column], err = toRaw(ovshelper.DefaultDatum(t)); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// convertUpdate2 converts update2 notification to update format, so it can be
+// applied as usual. Modify diffs are applied to cached rows.
+func (cache *Cache) convertUpdate2(response json.RawMessage) (map[string]map[string]dbmonitor.RowUpdate, error) {
+	var update2 map[string]map[string]map[string]map[string]interface{}
+	if err := decodeRaw(response, &update2); err != nil {
+		return nil, err
+	}
+
+	update := make(map[string]map[string]dbmonitor.RowUpdate, len(update2))
+	for table, rows := range update2 {
+		update[table] = make(map[string]dbmonitor.RowUpdate, len(rows))
+		for uuid, rowUpdate := range rows {
+			for op, row := range rowUpdate {
+				switch op {
+				case "initial", "insert":
+					row, err := cache.withDefaults(table, row)
+					if err != nil {
+						return nil, err
+					}
+					update[table][uuid] = dbmonitor.RowUpdate{New: row}
+				case "delete":
+					old := cache.rows[table][uuid]
+					if old == nil {
+						old = map[string]interface{}{}
+					}
+					update[table][uuid] = dbmonitor.RowUpdate{Old: old}
+				case "modify":
+					current := cache.rows[table][uuid]
+					if current == nil {
+						return nil, errors.New(fmt.Sprintf("modify of unknown %s row %s", table, uuid))
+					}
+					old := map[string]interface{}{}
+					new := copyRow(current)
+					for column, diff := range row {
+						t, err := cache.columnType(table, column)
+						if err != nil {
+							return nil, err
+						}
+						datum, err := ovshelper.DecodeDatum(t, current[column])
+						if err != nil {
+							return nil, err
+						}
+						d, err := ovshelper.DecodeDatum(t, diff)
+						if err != nil {
+							return nil, err
+						}
+						applied, err := ovshelper.ApplyDiff(t, datum, d)
+						if err != nil {
+							return nil, err
+						}
+						if new[column], err = toRaw(applied); err != nil {
+							return nil, err
+						}
+						old[column] = current[column]
+					}
+					update[table][uuid] = dbmonitor.RowUpdate{Old: old, New: new}
+				}
+			}
+		}
+	}
+	return update, nil
+}
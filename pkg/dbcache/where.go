@@ -0,0 +1,87 @@
+package dbcache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+)
+
+type condition struct {
+	column   string
+	function string
+	value    interface{}
+}
+
+func parseWhere(where [][]interface{}) ([]condition, error) {
+	conditions := make([]condition, len(where))
+	for i, c := range where {
+		if len(c) != 3 {
+			return nil, errors.New(fmt.Sprintf("condition must have 3 elements: %v", c))
+		}
+		column, _ := c[0].(string)
+		function, _ := c[1].(string)
+		value, err := ovshelper.ToDatum(c[2])
+		if err != nil {
+			return nil, err
+		}
+		conditions[i] = condition{column: column, function: function, value: value}
+	}
+	return conditions, nil
+}
+
+func matchRow(uuid string, row map[string]interface{}, conditions []condition) (bool, error) {
+	for _, c := range conditions {
+		var columnValue interface{}
+		if c.column == "_uuid" {
+			columnValue = ovshelper.UUID(uuid)
+		} else {
+			raw, ok := row[c.column]
+			if !ok {
+				return false, errors.New(fmt.Sprintf("column %s is not cached", c.column))
+			}
+			var err error
+			columnValue, err = ovshelper.ParseDatum(raw)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		ok, err := ovshelper.EvalCondition(c.function, columnValue, c.value)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// MatchRow evaluates RFC 7047 where clause for row in OVSDB notation. The
+// same where clauses are used by dbtransaction, so row selection can be done
+// both locally and on server.
+func MatchRow(uuid string, row map[string]interface{}, where [][]interface{}) (bool, error) {
+	conditions, err := parseWhere(where)
+	if err != nil {
+		return false, err
+	}
+	return matchRow(uuid, row, conditions)
+}
+
+// Select returns sorted uuids of table rows matching where clause.
+func (s *Snapshot) Select(table string, where [][]interface{}) ([]string, error) {
+	conditions, err := parseWhere(where)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, uuid := range s.rows[table].ids() {
+		row, _ := s.rows[table].get(uuid)
+		ok, err := matchRow(uuid, row, conditions)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ids = append(ids, uuid)
+		}
+	}
+	return ids, nil
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"strconv"
+	"sync"
 )
 
 type iOVSDB interface {
@@ -12,6 +13,30 @@ type iOVSDB interface {
 	GetCounter() uint64
 }
 
+// optional OVSDB handle features, monitor lifecycle uses them if present
+type callbackRemover interface {
+	RemoveCallBack(string)
+}
+
+type canceledNotifier interface {
+	AddCanceledCallBack(string, func())
+}
+
+type closeNotifier interface {
+	AddCloseCallback(string, func())
+}
+
+type closeCallbackRemover interface {
+	RemoveCloseCallback(string)
+}
+
+// Reasons why monitor ended, see Err.
+var (
+	ErrStopped  = errors.New("monitor stopped")
+	ErrCanceled = errors.New("monitor canceled by server")
+	ErrClosed   = errors.New("connection closed")
+)
+
 type RowUpdate struct {
 	New map[string]interface{}	`json:"new"`
 	Old map[string]interface{}	`json:"old"`
@@ -26,6 +51,7 @@ type Select struct {
 
 type Table struct {
 	Columns []string 	`json:"columns,omitempty"`
+	Where [][]interface{}	`json:"where,omitempty"` // only used by monitor_cond, see StartConditional
 	Select Select 		`json:"select"`
 }
 
@@ -36,6 +62,15 @@ type Monitor struct {
 	Schema string
 	MonitorRequests map[string]interface{}
 	id string
+	callback Callback
+	method string
+	extra []interface{} // method parameters after monitor requests, used by Restart
+	handler Callback // callback registered on OVSDB, see track
+	lastTxnId string // last transaction seen by monitor_cond_since monitor
+	mutex sync.Mutex
+	running bool
+	done chan struct{}
+	err error
 }
 
 func (monitor *Monitor) Register(tableName string, monitorTable interface{}) {
@@ -43,28 +78,293 @@ func (monitor *Monitor) Register(tableName string, monitorTable interface{}) {
 }
 
 func (monitor *Monitor) Start (callback Callback) (json.RawMessage, error) {
-	monitor.id = "monitor-" + strconv.FormatUint(monitor.OVSDB.GetCounter(), 10)
+	return monitor.start("monitor", callback)
+}
+
+// StartConditional starts monitor using monitor_cond method, which allows
+// to filter rows with Where conditions of registered tables. Both initial
+// response and update notifications are in update2 format.
+func (monitor *Monitor) StartConditional (callback Callback) (json.RawMessage, error) {
+	return monitor.start("monitor_cond", callback)
+}
+
+// StartConditionalSince starts monitor using monitor_cond_since method. Server
+// sends only changes after lastTxnId if it still has them, empty lastTxnId
+// requests all rows. Response is [found, last-txn-id, updates] with updates
+// in update2 format. Callback receives update3 notification parameters
+// [last-txn-id, updates].
+func (monitor *Monitor) StartConditionalSince (lastTxnId string, callback Callback) (json.RawMessage, error) {
+	if lastTxnId == "" {
+		lastTxnId = "00000000-0000-0000-0000-000000000000"
+	}
+	return monitor.start("monitor_cond_since", callback, lastTxnId)
+}
+
+// start sends monitor request. Callback is registered before request, so
+// notifications server sends right after reply are not lost, callback can
+// be called with them before start returns.
+func (monitor *Monitor) start(method string, callback Callback, extra ...interface{}) (json.RawMessage, error) {
+	id := "monitor-" + strconv.FormatUint(monitor.OVSDB.GetCounter(), 10)
+
+	monitor.mutex.Lock()
+	if monitor.running {
+		monitor.mutex.Unlock()
+		return nil, errors.New("monitor is running")
+	}
+	monitor.id = id
+	monitor.method = method
+	monitor.callback = callback
+	monitor.extra = extra
+	monitor.handler = monitor.track(method, callback)
+	monitor.lastTxnId = ""
+	monitor.done = make(chan struct{})
+	monitor.err = nil
+	handler := monitor.handler
+	monitor.mutex.Unlock()
+
+	// OVSDB handle locks callbacks while they are registered and may call
+	// them locked, handler locks monitor, so monitor is not locked here
+	monitor.OVSDB.AddCallBack(id, handler)
+
 	args := []interface {}{
 		monitor.Schema,
-		monitor.id,
+		id,
 		monitor.MonitorRequests,
 	}
+	args = append(args, extra...)
 
-	response, err := monitor.OVSDB.Call("monitor", args, nil)
+	response, err := monitor.OVSDB.Call(method, args, nil)
 
-	if err == nil {
-		monitor.OVSDB.AddCallBack(monitor.id, callback)
+	if err != nil {
+		if remover, ok := monitor.OVSDB.(callbackRemover); ok {
+			remover.RemoveCallBack(id)
+		}
+		monitor.mutex.Lock()
+		monitor.err = err
+		close(monitor.done)
+		monitor.mutex.Unlock()
+		return response, err
 	}
 
+	monitor.mutex.Lock()
+	monitor.running = true
+	// notifications which came meanwhile have newer transaction id
+	if method == "monitor_cond_since" && monitor.lastTxnId == "" {
+		var reply []json.RawMessage
+		if json.Unmarshal(response, &reply) == nil && len(reply) == 3 {
+			json.Unmarshal(reply[1], &monitor.lastTxnId)
+		}
+	}
+	monitor.mutex.Unlock()
+	monitor.watch(id)
+
 	return response, err
 }
 
-func (monitor *Monitor) Cancel() (interface{}, error) {
-	response, err := monitor.OVSDB.Call("monitor_cancel", []string{ monitor.id }, nil)
-	if err == nil {
-		return response, nil
-	} else {
-		return nil, errors.New("cancel failed")
+// track returns callback which remembers last transaction id of update3
+// notifications before passing them to callback, so Restart can continue
+// after it. Callbacks of other methods are returned unchanged.
+func (monitor *Monitor) track(method string, callback Callback) Callback {
+	if method != "monitor_cond_since" {
+		return callback
+	}
+	return func(response json.RawMessage) {
+		var params []json.RawMessage
+		if json.Unmarshal(response, &params) == nil && len(params) == 2 {
+			var lastTxnId string
+			if json.Unmarshal(params[0], &lastTxnId) == nil {
+				monitor.mutex.Lock()
+				monitor.lastTxnId = lastTxnId
+				monitor.mutex.Unlock()
+			}
+		}
+		callback(response)
+	}
+}
+
+// watch registers callbacks which end monitor with id when server cancels
+// it or connection is closed.
+func (monitor *Monitor) watch(id string) {
+	if notifier, ok := monitor.OVSDB.(canceledNotifier); ok {
+		notifier.AddCanceledCallBack(id, func() {
+			monitor.end(id, ErrCanceled)
+		})
+	}
+	if notifier, ok := monitor.OVSDB.(closeNotifier); ok {
+		notifier.AddCloseCallback(id, func() {
+			monitor.end(id, ErrClosed)
+		})
 	}
 }
 
+// unwatch removes callbacks of monitor with id.
+func (monitor *Monitor) unwatch(id string) {
+	if remover, ok := monitor.OVSDB.(callbackRemover); ok {
+		remover.RemoveCallBack(id)
+	}
+	if remover, ok := monitor.OVSDB.(closeCallbackRemover); ok {
+		remover.RemoveCloseCallback(id)
+	}
+}
+
+// end ends running monitor with id for reason.
+func (monitor *Monitor) end(id string, reason error) {
+	monitor.mutex.Lock()
+	if !monitor.running || monitor.id != id {
+		monitor.mutex.Unlock()
+		return
+	}
+	monitor.running = false
+	monitor.err = reason
+	close(monitor.done)
+	monitor.mutex.Unlock()
+
+	monitor.unwatch(id)
+}
+
+// Done returns channel which is closed when monitor ends, see Err for the
+// reason. Restart makes new channel.
+func (monitor *Monitor) Done() <-chan struct{} {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
+	if monitor.done == nil {
+		monitor.done = make(chan struct{})
+	}
+	return monitor.done
+}
+
+// Err returns why monitor ended: ErrStopped after Stop, ErrCanceled if
+// server canceled it, ErrClosed if connection was closed, error of failed
+// start, or error of update Events could not decode. It is nil while monitor
+// runs.
+func (monitor *Monitor) Err() error {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
+	return monitor.err
+}
+
+// ID returns id of monitor used in notifications, it changes on Restart and
+// ChangeConditions.
+func (monitor *Monitor) ID() string {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
+	return monitor.id
+}
+
+// Stop cancels monitor on server and removes its callback, so no more
+// notifications are received even if server fails to cancel. Error of server
+// is returned. Stop of monitor which is not running does nothing.
+func (monitor *Monitor) Stop() error {
+	monitor.mutex.Lock()
+	id, running := monitor.id, monitor.running
+	monitor.mutex.Unlock()
+	if !running {
+		return nil
+	}
+
+	_, err := monitor.OVSDB.Call("monitor_cancel", []string{id}, nil)
+	monitor.end(id, ErrStopped)
+	return err
+}
+
+// fail ends running monitor for reason from its own callback. Callback is
+// called by connection reader with callbacks locked, so monitor is canceled
+// on server and its callbacks are removed in background.
+func (monitor *Monitor) fail(reason error) {
+	monitor.mutex.Lock()
+	id, running := monitor.id, monitor.running
+	if !running {
+		monitor.mutex.Unlock()
+		return
+	}
+	monitor.running = false
+	monitor.err = reason
+	close(monitor.done)
+	monitor.mutex.Unlock()
+
+	go func() {
+		monitor.OVSDB.Call("monitor_cancel", []string{id}, nil)
+		monitor.unwatch(id)
+	}()
+}
+
+// Restart stops monitor if it runs and starts it again with the same method,
+// requests and callback. Initial rows are returned again, monitor_cond_since
+// monitor asks for changes after the last transaction it has seen, so
+// response has only changes missed meanwhile if server still has them.
+func (monitor *Monitor) Restart() (json.RawMessage, error) {
+	monitor.mutex.Lock()
+	method, callback, extra := monitor.method, monitor.callback, monitor.extra
+	if method == "monitor_cond_since" && monitor.lastTxnId != "" {
+		extra = []interface{}{monitor.lastTxnId}
+	}
+	monitor.mutex.Unlock()
+	if method == "" {
+		return nil, errors.New("monitor was not started")
+	}
+
+	monitor.Stop()
+	return monitor.start(method, callback, extra...)
+}
+
+// ChangeConditions replaces Where conditions of tables of running
+// monitor_cond or monitor_cond_since monitor, tables map table names to new
+// conditions. Server sends rows which start or stop matching as inserts and
+// deletes to the same callback. Nil conditions select all rows. Columns and
+// tables can't be changed.
+func (monitor *Monitor) ChangeConditions(tables map[string][][]interface{}) (json.RawMessage, error) {
+	requests := make(map[string]interface{}, len(tables))
+	for table, where := range tables {
+		var conditions interface{} = where
+		if where == nil {
+			conditions = []interface{}{true}
+		}
+		requests[table] = []interface{}{map[string]interface{}{"where": conditions}}
+	}
+
+	monitor.mutex.Lock()
+	oldId, running, handler := monitor.id, monitor.running, monitor.handler
+	monitor.mutex.Unlock()
+	if !running {
+		return nil, errors.New("monitor is not running")
+	}
+
+	// updates for new conditions come with new id and may arrive before reply
+	id := "monitor-" + strconv.FormatUint(monitor.OVSDB.GetCounter(), 10)
+	monitor.OVSDB.AddCallBack(id, handler)
+
+	response, err := monitor.OVSDB.Call("monitor_cond_change", []interface{}{oldId, id, requests}, nil)
+	if err != nil {
+		if remover, ok := monitor.OVSDB.(callbackRemover); ok {
+			remover.RemoveCallBack(id)
+		}
+		return response, err
+	}
+
+	monitor.mutex.Lock()
+	monitor.id = id
+	monitor.mutex.Unlock()
+	monitor.unwatch(oldId)
+	monitor.watch(id)
+	return response, err
+}
+
+// Cancel works like Stop, response of server is returned.
+func (monitor *Monitor) Cancel() (interface{}, error) {
+	monitor.mutex.Lock()
+	id, running := monitor.id, monitor.running
+	monitor.mutex.Unlock()
+	if !running {
+		return nil, errors.New("monitor is not running")
+	}
+
+	response, err := monitor.OVSDB.Call("monitor_cancel", []string{id}, nil)
+	monitor.end(id, ErrStopped)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
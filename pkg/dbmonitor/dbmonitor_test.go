@@ -0,0 +1,301 @@
+package dbmonitor_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbmonitor"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/internal/ovsdbtest"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonitor_Lifecycle(t *testing.T) {
+	var cancels []interface{}
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		switch method {
+		case "monitor":
+			return json.RawMessage(`{}`), nil
+		case "monitor_cancel":
+			cancels = append(cancels, args[0])
+			return nil, errors.New("unknown monitor")
+		}
+		return nil, nil
+	})
+
+	updates := 0
+	monitor := f.Monitor("Open_vSwitch")
+	monitor.Register("Bridge", dbmonitor.Table{Select: dbmonitor.Select{Insert: true}})
+	if _, err := monitor.Start(func(json.RawMessage) { updates++ }); err != nil {
+		t.Fatal(err)
+	}
+	isDone := func() bool {
+		select {
+		case <-monitor.Done():
+			return true
+		default:
+			return false
+		}
+	}
+	id := monitor.ID()
+	if isDone() || monitor.Err() != nil {
+		t.Error("Started monitor is done")
+	}
+
+	// server error is returned, but monitor is stopped anyway
+	if err := monitor.Stop(); err == nil || err.Error() != "unknown monitor" {
+		t.Error("Server error not returned:", err)
+	}
+	f.Update(`{}`)
+	if !isDone() || monitor.Err() != dbmonitor.ErrStopped || updates != 0 || len(cancels) != 1 || cancels[0] != id {
+		t.Error("Monitor not stopped")
+	}
+	if err := monitor.Stop(); err != nil || len(cancels) != 1 {
+		t.Error("Stopped monitor stopped again")
+	}
+
+	if _, err := monitor.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	f.Update(`{}`)
+	if isDone() || monitor.Err() != nil || monitor.ID() == id || updates != 1 {
+		t.Error("Monitor not restarted")
+	}
+
+	f.Cancel(monitor.ID())
+	if !isDone() || monitor.Err() != dbmonitor.ErrCanceled {
+		t.Error("Monitor canceled by server not done:", monitor.Err())
+	}
+
+	monitor.Restart()
+	f.Close()
+	if !isDone() || monitor.Err() != dbmonitor.ErrClosed {
+		t.Error("Monitor not done after connection is closed:", monitor.Err())
+	}
+
+}
+
+func TestMonitor_RestartSince(t *testing.T) {
+	var since []interface{}
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		switch method {
+		case "monitor_cond_since":
+			since = append(since, args[3])
+			return json.RawMessage(`[false, "` + ovsdbtest.RootId + `", {}]`), nil
+		case "monitor_cancel":
+			return json.RawMessage(`{}`), nil
+		}
+		return nil, nil
+	})
+
+	var updates []string
+	monitor := f.Monitor("Open_vSwitch")
+	monitor.Register("Bridge", dbmonitor.Table{Select: dbmonitor.Select{Initial: true}})
+	if _, err := monitor.StartConditionalSince("", func(response json.RawMessage) {
+		updates = append(updates, string(response))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// without notifications monitor continues after transaction of reply
+	if _, err := monitor.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	f.Update(`["` + ovsdbtest.BridgeId + `", {}]`)
+	if _, err := monitor.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{"00000000-0000-0000-0000-000000000000", ovsdbtest.RootId, ovsdbtest.BridgeId}
+	if !reflect.DeepEqual(since, expected) {
+		t.Error("Restart did not continue after last transaction:", since)
+	}
+	if len(updates) != 1 {
+		t.Error("Notification not passed to callback:", updates)
+	}
+}
+
+func TestMonitor_EarlyNotification(t *testing.T) {
+	fail := false
+	var f *ovsdbtest.FakeOVSDB
+	f = ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method == "monitor" {
+			// server can send update right after reply
+			f.NotifyMonitor(args[1].(string), `{"Bridge": {}}`)
+			if fail {
+				return nil, errors.New("unknown database")
+			}
+			return json.RawMessage(`{}`), nil
+		}
+		return nil, nil
+	})
+
+	updates := 0
+	monitor := f.Monitor("Open_vSwitch")
+	monitor.Register("Bridge", dbmonitor.Table{})
+	if _, err := monitor.Start(func(json.RawMessage) { updates++ }); err != nil {
+		t.Fatal(err)
+	}
+	if updates != 1 {
+		t.Error("Notification sent right after reply lost")
+	}
+	monitor.Stop()
+
+	fail = true
+	if _, err := monitor.Restart(); err == nil {
+		t.Fatal("Failed start not reported")
+	}
+	if ids := f.MonitorIds(); len(ids) != 0 {
+		t.Error("Callback of failed start kept:", ids)
+	}
+}
+
+func TestMonitor_Events(t *testing.T) {
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method == "monitor" {
+			return json.RawMessage(ovsdbtest.InitialUpdate), nil
+		}
+		return nil, nil
+	})
+	monitor := f.Monitor("Open_vSwitch")
+	monitor.Register("Open_vSwitch", dbmonitor.Table{})
+	monitor.Register("Bridge", dbmonitor.Table{})
+	events, err := monitor.Events()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := func() (dbmonitor.Event, bool) {
+		select {
+		case event, ok := <-events:
+			return event, ok
+		case <-time.After(time.Second):
+			t.Fatal("No event received")
+		}
+		return dbmonitor.Event{}, false
+	}
+
+	// initial rows are ordered by table and uuid
+	bridge, _ := next()
+	root, _ := next()
+	if bridge.Kind != dbmonitor.EventInitial || bridge.Table != "Bridge" || bridge.UUID != ovsdbtest.BridgeId || bridge.Old != nil {
+		t.Error("Wrong initial event:", bridge)
+	}
+	if root.Kind != dbmonitor.EventInitial || root.Table != "Open_vSwitch" ||
+		root.New["next_cfg"] != int64(3) || !reflect.DeepEqual(root.New["bridges"], ovshelper.Set{ovshelper.UUID(ovsdbtest.BridgeId)}) {
+		t.Error("Wrong initial event:", root)
+	}
+	if !reflect.DeepEqual(bridge.New["external_ids"], ovshelper.Map{"owner": "test"}) {
+		t.Error("Initial row not decoded with schema:", bridge.New)
+	}
+
+	f.Update(`{"Bridge": {"` + ovsdbtest.BridgeId + `": {
+		"old": {"flood_vlans": ["set", []]},
+		"new": {"name": "br0", "flood_vlans": ["set", [10, 20]], "ports": ["set", []], "external_ids": ["map", []]}}}}`)
+	modify, _ := next()
+	if modify.Kind != dbmonitor.EventModify || !reflect.DeepEqual(modify.New["flood_vlans"], ovshelper.Set{int64(10), int64(20)}) ||
+		!reflect.DeepEqual(modify.Old, map[string]interface{}{"flood_vlans": ovshelper.Set{}}) {
+		t.Error("Wrong modify event:", modify)
+	}
+	old, model, err := dbmonitor.DecodeEvent[ovsdbtest.Bridge](modify)
+	if err != nil || old == nil || model == nil || model.Name != "br0" || model.UUID != ovsdbtest.BridgeId {
+		t.Error("Event not decoded to model:", old, model, err)
+	}
+
+	f.Update(`{"Bridge": {"` + ovsdbtest.BridgeId + `": {"old": {"name": "br0"}}}}`)
+	f.Update(`{"Bridge": {"` + ovsdbtest.BridgeId + `": {"new": {"name": "br1"}}}}`)
+	if event, _ := next(); event.Kind != dbmonitor.EventDelete || event.Old["name"] != "br0" || event.New != nil {
+		t.Error("Wrong delete event:", event)
+	}
+	if event, _ := next(); event.Kind != dbmonitor.EventInsert || event.New["name"] != "br1" {
+		t.Error("Wrong insert event:", event)
+	}
+	_, model, _ = dbmonitor.DecodeEvent[ovsdbtest.Bridge](dbmonitor.Event{})
+	if model != nil {
+		t.Error("Model decoded without row")
+	}
+
+	// events not read before monitor ends may be dropped, but channel is
+	// closed even if reader stopped reading
+	closed := func() {
+		for i := 0; i < 3; i++ {
+			if _, ok := next(); !ok {
+				return
+			}
+		}
+		t.Error("Channel not closed after monitor is stopped")
+	}
+	f.Update(`{"Bridge": {"` + ovsdbtest.BridgeId + `": {"old": {"name": "br1"}}}}`)
+	f.Update(`{"Bridge": {"` + ovsdbtest.BridgeId + `": {"new": {"name": "br2"}}}}`)
+	monitor.Stop()
+	time.Sleep(10 * time.Millisecond)
+	closed()
+
+	monitor = f.Monitor("Open_vSwitch")
+	monitor.Register("Bridge", dbmonitor.Table{})
+	if events, err = monitor.Events(); err != nil {
+		t.Fatal(err)
+	}
+	monitor.Stop()
+	time.Sleep(10 * time.Millisecond)
+	closed()
+
+	monitor = f.Monitor("Open_vSwitch")
+	monitor.Register("Bridge", dbmonitor.Table{})
+	if events, err = monitor.Events(); err != nil {
+		t.Fatal(err)
+	}
+	next()
+	next()
+	f.Close()
+	if _, ok := next(); ok {
+		t.Error("Channel not closed after connection is closed")
+	}
+}
+
+func TestMonitor_EventsDecodeError(t *testing.T) {
+	// monitor is canceled in background, fake is not used after release
+	release := make(chan struct{})
+	defer close(release)
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		switch method {
+		case "monitor":
+			return json.RawMessage(`{}`), nil
+		case "monitor_cancel":
+			<-release
+		}
+		return nil, nil
+	})
+	monitor := f.Monitor("Open_vSwitch")
+	monitor.Register("Bridge", dbmonitor.Table{})
+	events, err := monitor.Events()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Update(`{"Bridge": {"` + ovsdbtest.BridgeId + `": {"new": {"name": "br0"}}}}`)
+	f.Update(`{"Bridge": []}`)
+	received := 0
+	for range events {
+		received++
+	}
+	if received > 1 {
+		t.Error("Events received after malformed update:", received)
+	}
+	if err := monitor.Err(); err == nil || !strings.HasPrefix(err.Error(), "malformed monitor update") {
+		t.Error("Decode error not returned:", err)
+	}
+
+	f = ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method == "monitor" {
+			return json.RawMessage(`[]`), nil
+		}
+		return nil, nil
+	})
+	monitor = f.Monitor("Open_vSwitch")
+	monitor.Register("Bridge", dbmonitor.Table{})
+	if _, err := monitor.Events(); err == nil || monitor.Err() != err {
+		t.Error("Malformed initial rows accepted:", err)
+	}
+}
@@ -0,0 +1,243 @@
+package dbmonitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+	"sync"
+)
+
+type EventKind string
+
+const (
+	EventInitial EventKind = "initial"
+	EventInsert  EventKind = "insert"
+	EventModify  EventKind = "modify"
+	EventDelete  EventKind = "delete"
+)
+
+// Event is a change of one row. Values of Old and New are datums of schema
+// column types, see ovshelper.DecodeDatum. Use DecodeEvent to decode rows to
+// model structs.
+type Event struct {
+	Table string
+	UUID  string
+	Kind  EventKind
+	Old   map[string]interface{} // changed columns before modify, whole row before delete
+	New   map[string]interface{} // whole row after initial, insert and modify
+	old   map[string]interface{} // rows in OVSDB notation
+	new   map[string]interface{}
+}
+
+type schemaProvider interface {
+	GetParsedSchema(string) (*ovshelper.Schema, error)
+}
+
+// eventQueue passes events from callback to channel. Callback is called by
+// connection reader, so it must not wait for slow readers of channel.
+type eventQueue struct {
+	sync.Mutex
+	cond    *sync.Cond
+	events  []Event
+	started bool          // initial events are queued
+	closed  bool          // monitor ended
+	done    chan struct{} // closed with closed
+}
+
+// Events starts monitor with "monitor" method and returns channel of its
+// events. Initial rows come first as EventInitial events, followed by
+// changes in order in which server sent them. Channel is closed when monitor
+// ends, see Done and Err, events not read by then are dropped. If
+// notification can't be decoded, monitor is stopped and Err returns decode
+// error.
+//
+//	events, err := monitor.Events()
+//	for event := range events {
+//		_, bridge, err := dbmonitor.DecodeEvent[Bridge](event)
+//		...
+//	}
+func (monitor *Monitor) Events() (<-chan Event, error) {
+	schema, err := monitor.schema()
+	if err != nil {
+		return nil, err
+	}
+
+	queue := &eventQueue{done: make(chan struct{})}
+	queue.cond = sync.NewCond(queue)
+
+	callback := func(response json.RawMessage) {
+		events, err := decodeEvents(schema, response, false)
+		if err != nil {
+			monitor.fail(err)
+			queue.close()
+			return
+		}
+		queue.Lock()
+		if !queue.closed {
+			queue.events = append(queue.events, events...)
+		}
+		queue.Unlock()
+		queue.cond.Broadcast()
+	}
+
+	ch := make(chan Event)
+	go queue.pump(ch)
+
+	response, err := monitor.Start(callback)
+	if err != nil {
+		queue.close()
+		return nil, err
+	}
+
+	initial, err := decodeEvents(schema, response, true)
+	if err != nil {
+		monitor.fail(err)
+		queue.close()
+		return nil, err
+	}
+
+	// notifications can come right after start, initial rows go before them
+	queue.Lock()
+	queue.events = append(initial, queue.events...)
+	queue.started = true
+	queue.Unlock()
+	queue.cond.Broadcast()
+
+	done := monitor.Done()
+	go func() {
+		<-done
+		queue.close()
+	}()
+
+	return ch, nil
+}
+
+func (monitor *Monitor) schema() (*ovshelper.Schema, error) {
+	if provider, ok := monitor.OVSDB.(schemaProvider); ok {
+		return provider.GetParsedSchema(monitor.Schema)
+	}
+	response, err := monitor.OVSDB.Call("get_schema", []string{monitor.Schema}, nil)
+	if err != nil {
+		return nil, err
+	}
+	schema := new(ovshelper.Schema)
+	if err := json.Unmarshal(response, schema); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+func (queue *eventQueue) close() {
+	queue.Lock()
+	if !queue.closed {
+		queue.closed = true
+		queue.events = nil
+		close(queue.done)
+	}
+	queue.Unlock()
+	queue.cond.Broadcast()
+}
+
+// pump sends queued events to channel and closes it when monitor ended.
+// Queued events are dropped then, so pump does not wait for readers which
+// stopped reading.
+func (queue *eventQueue) pump(ch chan<- Event) {
+	defer close(ch)
+	for {
+		queue.Lock()
+		for !queue.closed && (!queue.started || len(queue.events) == 0) {
+			queue.cond.Wait()
+		}
+		if queue.closed {
+			queue.Unlock()
+			return
+		}
+		event := queue.events[0]
+		queue.events = queue.events[1:]
+		queue.Unlock()
+
+		select {
+		case ch <- event:
+		case <-queue.done:
+			return
+		}
+	}
+}
+
+// decodeEvents converts update to events ordered by table and uuid.
+func decodeEvents(schema *ovshelper.Schema, response json.RawMessage, initial bool) ([]Event, error) {
+	var update map[string]map[string]RowUpdate
+	dec := json.NewDecoder(bytes.NewReader(response))
+	dec.UseNumber()
+	if err := dec.Decode(&update); err != nil {
+		return nil, errors.New(fmt.Sprintf("malformed monitor update: %s", err))
+	}
+
+	var events []Event
+	for _, table := range ovshelper.SortedKeys(update) {
+		for _, uuid := range ovshelper.SortedKeys(update[table]) {
+			rowUpdate := update[table][uuid]
+			event := Event{Table: table, UUID: uuid, old: rowUpdate.Old, new: rowUpdate.New}
+			switch {
+			case initial:
+				event.Kind = EventInitial
+			case rowUpdate.Old == nil:
+				event.Kind = EventInsert
+			case rowUpdate.New == nil:
+				event.Kind = EventDelete
+			default:
+				event.Kind = EventModify
+			}
+			event.Old = decodeRow(schema, table, rowUpdate.Old)
+			event.New = decodeRow(schema, table, rowUpdate.New)
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// decodeRow converts row to datums of column types. Columns unknown to
+// schema are parsed without it.
+func decodeRow(schema *ovshelper.Schema, table string, row map[string]interface{}) map[string]interface{} {
+	if row == nil {
+		return nil
+	}
+	columns := schema.Tables[table].Columns
+	ret := make(map[string]interface{}, len(row))
+	for column, raw := range row {
+		if def, ok := columns[column]; ok {
+			if datum, err := ovshelper.DecodeDatum(def.Type, raw); err == nil {
+				ret[column] = datum
+				continue
+			}
+		}
+		if datum, err := ovshelper.ParseDatum(raw); err == nil {
+			ret[column] = datum
+		} else {
+			ret[column] = raw
+		}
+	}
+	return ret
+}
+
+// DecodeEvent decodes rows of event to model structs, see
+// ovshelper.DecodeRow. Old is nil unless event is modify or delete, for
+// modify it has only changed columns set. New is nil for delete.
+func DecodeEvent[T any](event Event) (*T, *T, error) {
+	var oldModel, newModel *T
+	if event.old != nil {
+		oldModel = new(T)
+		if err := ovshelper.DecodeRow(event.UUID, event.old, oldModel); err != nil {
+			return nil, nil, err
+		}
+	}
+	if event.new != nil {
+		newModel = new(T)
+		if err := ovshelper.DecodeRow(event.UUID, event.new, newModel); err != nil {
+			return nil, nil, err
+		}
+	}
+	return oldModel, newModel, nil
+}
@@ -0,0 +1,112 @@
+package dbtransaction
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+)
+
+// Condition is a single RFC 7047 condition: [column, function, value].
+// Conditions are combined into a where clause with Where, which can be
+// passed to Select, Update, Mutate, Delete and Wait as well as to
+// dbmonitor.Table for monitor_cond requests.
+type Condition []interface{}
+
+// Where combines conditions into a where clause. Rows match when all
+// conditions are true.
+func Where(conditions ...Condition) [][]interface{} {
+	where := make([][]interface{}, len(conditions))
+	for i, c := range conditions {
+		where[i] = []interface{}(c)
+	}
+	return where
+}
+
+func Equal(column string, value interface{}) Condition {
+	return Condition{column, "==", value}
+}
+
+func NotEqual(column string, value interface{}) Condition {
+	return Condition{column, "!=", value}
+}
+
+// LessThan and the other ordering conditions are only valid for integer and
+// real columns.
+func LessThan(column string, value interface{}) Condition {
+	return Condition{column, "<", value}
+}
+
+func LessThanOrEqual(column string, value interface{}) Condition {
+	return Condition{column, "<=", value}
+}
+
+func GreaterThan(column string, value interface{}) Condition {
+	return Condition{column, ">", value}
+}
+
+func GreaterThanOrEqual(column string, value interface{}) Condition {
+	return Condition{column, ">=", value}
+}
+
+// Includes matches rows whose set or map column contains all of the given
+// elements or pairs.
+func Includes(column string, value interface{}) Condition {
+	return Condition{column, "includes", value}
+}
+
+// Excludes matches rows whose set or map column contains none of the given
+// elements or pairs.
+func Excludes(column string, value interface{}) Condition {
+	return Condition{column, "excludes", value}
+}
+
+// HasUUID matches the row with the given uuid.
+func HasUUID(uuid string) Condition {
+	return Equal("_uuid", ovshelper.UUID(uuid))
+}
+
+// HasNamedUUID matches the row inserted earlier in the same transaction under
+// the given uuid-name.
+func HasNamedUUID(name string) Condition {
+	return Equal("_uuid", ovshelper.NamedUUID(name))
+}
+
+var conditionFunctions = map[string]bool{
+	"<": true, "<=": true, "==": true, "!=": true, ">=": true, ">": true,
+	"includes": true, "excludes": true,
+}
+
+var orderingFunctions = map[string]bool{
+	"<": true, "<=": true, ">=": true, ">": true,
+}
+
+// validateWhere checks that where clause is well formed, so mistakes are
+// reported before the transaction is sent to the server.
+func validateWhere(where [][]interface{}) error {
+	for _, c := range where {
+		if len(c) != 3 {
+			return errors.New(fmt.Sprintf("condition must have 3 elements: %v", c))
+		}
+		column, ok := c[0].(string)
+		if !ok || column == "" {
+			return errors.New(fmt.Sprintf("condition column must be non empty string: %v", c))
+		}
+		function, ok := c[1].(string)
+		if !ok || !conditionFunctions[function] {
+			return errors.New(fmt.Sprintf("unknown condition function: %v", c[1]))
+		}
+		if orderingFunctions[function] && !isNumber(c[2]) {
+			return errors.New(fmt.Sprintf("condition function %s requires integer or real value: %v", function, c))
+		}
+	}
+	return nil
+}
+
+func isNumber(value interface{}) bool {
+	switch value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, json.Number:
+		return true
+	}
+	return false
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbcache"
 	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/helpers"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
 	"strconv"
 )
 
@@ -16,8 +17,10 @@ type iOVSDB interface {
 type UUID []string
 
 type ActionResponse struct {
+	Op      string        `json:"-"` // operation this result belongs to, filled by Commit
 	Rows    []interface{} `json:"rows"`
 	UUID    UUID
+	Count   int           `json:"count"`
 	Error   string
 	Details string
 }
@@ -32,7 +35,23 @@ type Transaction struct {
 	Tables     map[string]string
 	References map[string][]interface{}
 	Counter    int
+	GCCheck    GCCheck        // check inserts into non-root tables on commit
+	Warnings   []string       // filled by Commit when GCCheck is GCWarn
+	Collected  []CollectedRow // inserted rows removed by garbage collection, best-effort, filled by Commit unless GCCheck is GCIgnore
+	Results    Transact       // operation results of last Commit, filled also when an operation failed
 	id         uint64
+	err        error
+	uuids      map[string]bool // explicit uuids used by Insert
+	reads      *readSet        // cache reads guarded with wait operations on commit
+	schemaDef  *ovshelper.Schema
+}
+
+// setError records first error found while staging operations. It is returned
+// by Commit without sending the transaction.
+func (txn *Transaction) setError(err error) {
+	if txn.err == nil && err != nil {
+		txn.err = err
+	}
 }
 
 func (txn *Transaction) Cancel() {
@@ -48,6 +67,8 @@ type Select struct {
 }
 
 func (txn *Transaction) Select(s Select) {
+	txn.setError(validateWhere(s.Where))
+
 	action := map[string]interface{}{}
 
 	action["op"] = "select"
@@ -66,17 +87,39 @@ func (txn *Transaction) Select(s Select) {
 	txn.Actions = append(txn.Actions, action)
 }
 
+// Query returns cache query for the same rows and columns, so select can be
+// answered from cache, see dbcache.Snapshot.Query.
+func (s Select) Query() dbcache.Query {
+	return dbcache.Query{Table: s.Table, Where: s.Where, Columns: s.Columns}
+}
+
 type Insert struct {
 	Table string
 	Row   interface{}
+	UUID  string // optional, lets client choose row uuid (needs newer ovsdb-server)
 }
 
+// Insert stages new row and returns its uuid-name, which can be used to refer
+// to the row from other operations in the same transaction.
 func (txn *Transaction) Insert(i Insert) string {
 	action := map[string]interface{}{}
 
 	tempId := "row" + strconv.Itoa(txn.Counter)
 	txn.Counter++
 
+	if i.UUID != "" {
+		if !ovshelper.IsUUID(i.UUID) {
+			txn.setError(errors.New("malformed insert uuid: " + i.UUID))
+		} else if txn.uuids[i.UUID] {
+			txn.setError(errors.New("insert uuid used more than once in transaction: " + i.UUID))
+		}
+		if txn.uuids == nil {
+			txn.uuids = make(map[string]bool)
+		}
+		txn.uuids[i.UUID] = true
+		action["uuid"] = i.UUID
+	}
+
 	action["uuid-name"] = tempId
 	action["row"] = i.Row
 	action["op"] = "insert"
@@ -112,6 +155,8 @@ func (txn *Transaction) Update(u Update) {
 		})
 	}
 
+	txn.setError(validateWhere(u.Where))
+
 	action := map[string]interface{}{}
 
 	action["op"] = "update"
@@ -133,6 +178,13 @@ type Mutate struct {
 }
 
 func (txn *Transaction) Mutate(m Mutate) {
+	txn.setError(validateWhere(m.Where))
+	if err := validateMutations(m.Mutations); err != nil {
+		txn.setError(err)
+	} else {
+		txn.setError(txn.validateMutationColumns(m.Table, m.Mutations))
+	}
+
 	action := map[string]interface{}{}
 
 	action["op"] = "mutate"
@@ -153,6 +205,8 @@ type Delete struct {
 }
 
 func (txn *Transaction) Delete(d Delete) {
+	txn.setError(validateWhere(d.Where))
+
 	action := map[string]interface{}{}
 
 	action["op"] = "delete"
@@ -172,6 +226,12 @@ type Wait struct {
 }
 
 func (txn *Transaction) Wait(w Wait) {
+	txn.setError(validateWhere(w.Where))
+
+	txn.Actions = append(txn.Actions, waitAction(w))
+}
+
+func waitAction(w Wait) map[string]interface{} {
 	action := map[string]interface{}{}
 
 	action["op"] = "wait"
@@ -182,13 +242,77 @@ func (txn *Transaction) Wait(w Wait) {
 	action["until"] = w.Until
 	action["rows"] = w.Rows
 
+	return action
+}
+
+// Comment adds comment to transaction, ovsdb-server writes it to the
+// database log.
+func (txn *Transaction) Comment(comment string) {
+	action := map[string]interface{}{}
+
+	action["op"] = "comment"
+	action["comment"] = comment
+
+	txn.Actions = append(txn.Actions, action)
+}
+
+// Abort makes transaction fail with "aborted" error and roll back all
+// operations. Useful for testing rollback paths.
+func (txn *Transaction) Abort() {
+	action := map[string]interface{}{}
+
+	action["op"] = "abort"
+
+	txn.Actions = append(txn.Actions, action)
+}
+
+// CommitDurable adds commit operation. With durable set server replies only
+// after changes are written to disk.
+func (txn *Transaction) CommitDurable(durable bool) {
+	action := map[string]interface{}{}
+
+	action["op"] = "commit"
+	action["durable"] = durable
+
+	txn.Actions = append(txn.Actions, action)
+}
+
+// Assert makes transaction fail with "not owner" error unless this session
+// owns the lock with given id.
+func (txn *Transaction) Assert(lock string) {
+	action := map[string]interface{}{}
+
+	action["op"] = "assert"
+	action["lock"] = lock
+
 	txn.Actions = append(txn.Actions, action)
 }
 
 // Commit stores all staged changes in DB. It manages references in main table
 // automatically.
 func (txn *Transaction) Commit() (Transact, error, bool) {
+	// staging errors are caused by caller, so retry would not help
+	if txn.err != nil {
+		return nil, txn.err, false
+	}
+
+	txn.Warnings = nil
+	txn.Collected = nil
+	txn.Results = nil
+	if txn.GCCheck != GCIgnore {
+		if err := txn.checkGC(); err != nil {
+			return nil, err, false
+		}
+	}
+
+	// guards go first, so they see rows before this transaction changes them
+	guards, err := txn.reads.guards()
+	if err != nil {
+		return nil, err, false
+	}
+
 	args := []interface{}{txn.Schema}
+	args = append(args, guards...)
 	args = append(args, txn.Actions...)
 
 	var id uint64
@@ -202,6 +326,26 @@ func (txn *Transaction) Commit() (Transact, error, bool) {
 	var t Transact
 	json.Unmarshal(response, &t)
 
+	// guard results are not returned, failed guard means that rows read from
+	// cache were changed meanwhile and transaction should be retried
+	if len(guards) > 0 {
+		for _, res := range t[:min(len(guards), len(t))] {
+			if res.Error != "" {
+				return nil, errors.New("timed out: cached rows changed: " + res.Details), true
+			}
+		}
+		t = t[min(len(guards), len(t)):]
+	}
+
+	for idx := range t {
+		if idx < len(txn.Actions) {
+			t[idx].Op, _ = txn.Actions[idx].(map[string]interface{})["op"].(string)
+		}
+	}
+	// results of failed transaction are kept, so caller can see which
+	// operation failed
+	txn.Results = t
+
 	// handle OVSDB errors
 	for _, res := range t {
 		if res.Error != "" {
@@ -218,6 +362,16 @@ func (txn *Transaction) Commit() (Transact, error, bool) {
 		return nil, errors.New(t[len(t)-1].Error + ": " + t[len(t)-1].Details), false
 	}
 
+	// transaction is done, failing to check garbage collection is only
+	// reported as warning
+	if txn.GCCheck != GCIgnore {
+		collected, err := txn.collected(t)
+		if err != nil {
+			txn.Warnings = append(txn.Warnings, "garbage collection check failed: "+err.Error())
+		}
+		txn.Collected = collected
+	}
+
 	return t, nil, false
 }
 
@@ -234,9 +388,53 @@ type DeleteReferences struct {
 	Wait            bool
 	Cache           *dbcache.Cache
 	LockChannel     chan int // used for locking for testing purposes
+	Mutate          bool     // delete references with mutate, current ids are only needed for Wait
+}
+
+// waitReferences waits until reference column still holds current ids. It is
+// used by mutate mode of InsertReferences and DeleteReferences, where current
+// ids are not otherwise needed.
+func (txn *Transaction) waitReferences(table string, whereId string, column string, currentIds []string, cache *dbcache.Cache) {
+	if currentIds == nil {
+		if cache == nil {
+			txn.setError(errors.New("wait on references requires CurrentIdsList or Cache"))
+			return
+		}
+		currentIds = cache.GetKeys(table, "uuid", whereId, column)
+	}
+
+	txn.Wait(Wait{
+		Table:   table,
+		Where:   Where(HasUUID(whereId)),
+		Columns: []string{column},
+		Until:   "==",
+		Rows: []interface{}{map[string]interface{}{
+			column: helpers.MakeOVSDBSet(map[string]interface{}{
+				"uuid": currentIds,
+			}),
+		}},
+	})
 }
 
 func (txn *Transaction) DeleteReferences(dr DeleteReferences) *Transaction {
+	if dr.Mutate {
+		if dr.Wait {
+			txn.waitReferences(dr.Table, dr.WhereId, dr.ReferenceColumn, dr.CurrentIdsList, dr.Cache)
+		}
+		txn.Mutate(Mutate{
+			Table:     dr.Table,
+			Where:     Where(HasUUID(dr.WhereId)),
+			Mutations: Mutations(DeleteUUIDs(dr.ReferenceColumn, dr.DeleteIdsList...)),
+		})
+
+		// lock for testing purposes
+		if dr.LockChannel != nil {
+			<-dr.LockChannel
+		}
+
+		return txn
+	}
+
 	var bridgeIdList []string
 	if dr.CurrentIdsList != nil {
 		bridgeIdList = dr.CurrentIdsList
@@ -283,9 +481,31 @@ type InsertReferences struct {
 	CurrentIdsList  		[]string
 	Wait            		bool
 	Cache           		*dbcache.Cache
+	Mutate          		bool // insert references with mutate, current ids are only needed for Wait
 }
 
 func (txn *Transaction) InsertReferences(ir InsertReferences) *Transaction {
+	if ir.Mutate {
+		var refs []interface{}
+		for _, id := range ir.InsertExistingIdsList {
+			refs = append(refs, ovshelper.UUID(id))
+		}
+		for _, id := range ir.InsertIdsList {
+			refs = append(refs, ovshelper.NamedUUID(id))
+		}
+
+		if ir.Wait {
+			txn.waitReferences(ir.Table, ir.WhereId, ir.ReferenceColumn, ir.CurrentIdsList, ir.Cache)
+		}
+		txn.Mutate(Mutate{
+			Table:     ir.Table,
+			Where:     Where(HasUUID(ir.WhereId)),
+			Mutations: Mutations(InsertToSet(ir.ReferenceColumn, refs...)),
+		})
+
+		return txn
+	}
+
 	var bridgeIdList []string
 	if ir.CurrentIdsList != nil {
 		bridgeIdList = ir.CurrentIdsList
@@ -0,0 +1,657 @@
+package dbtransaction_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbcache"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbtransaction"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/internal/ovsdbtest"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunTransaction_Retry(t *testing.T) {
+	var transacts [][]interface{}
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		switch method {
+		case "monitor":
+			return json.RawMessage(ovsdbtest.InitialUpdate), nil
+		case "transact":
+			transacts = append(transacts, args)
+			if len(transacts) == 1 {
+				return json.RawMessage(`[{"error": "timed out", "details": "\"wait\" timed out"}, null]`), nil
+			}
+			return json.RawMessage(`[{}, {"count": 1}]`), nil
+		}
+		return nil, nil
+	})
+	cache := ovsdbtest.NewCache(t, f)
+
+	res, err := dbtransaction.RunTransaction(context.Background(), f, cache, func(txn *dbtransaction.Transaction, view dbtransaction.CacheView) error {
+		bridge := view.GetMap("Bridge", "name", "br0")
+		txn.Update(dbtransaction.Update{
+			Table: "Bridge",
+			Where: dbtransaction.Where(dbtransaction.HasUUID(bridge["uuid"].(string))),
+			Row:   map[string]interface{}{"fail_mode": "secure"},
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transacts) != 2 {
+		t.Fatalf("Expected 2 attempts, got %d", len(transacts))
+	}
+	if len(res) != 1 || res[0].Op != "update" || res[0].Count != 1 {
+		t.Error("Guard results not stripped")
+	}
+
+	guard := transacts[1][1].(map[string]interface{})
+	encoded, _ := json.Marshal(guard)
+	expected := `{"columns":["external_ids","name","ports"],"op":"wait","rows":[{"external_ids":["map",[["owner","test"]]],"name":"br0","ports":["set",[]]}],` +
+		`"table":"Bridge","timeout":0,"until":"==","where":[["_uuid","==",["uuid","` + ovsdbtest.BridgeId + `"]]]}`
+	if string(encoded) != expected {
+		t.Error("Wrong guard: " + string(encoded))
+	}
+
+	// errors from fn are not retried
+	attempts := len(transacts)
+	_, err = dbtransaction.RunTransaction(context.Background(), f, cache, func(txn *dbtransaction.Transaction, view dbtransaction.CacheView) error {
+		return errors.New("stop")
+	})
+	if err == nil || len(transacts) != attempts {
+		t.Error("Error from fn was not returned")
+	}
+
+	// canceled context sends nothing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = dbtransaction.RunTransaction(ctx, f, cache, func(txn *dbtransaction.Transaction, view dbtransaction.CacheView) error {
+		t.Error("Transaction built with canceled context")
+		return nil
+	})
+	if err != context.Canceled || len(transacts) != attempts {
+		t.Error("Transaction sent with canceled context:", err)
+	}
+}
+
+func TestTransaction_View_Guards(t *testing.T) {
+	var transact []interface{}
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		switch method {
+		case "monitor":
+			return json.RawMessage(ovsdbtest.InitialUpdate), nil
+		case "transact":
+			transact = args
+			return json.RawMessage(`[{}, {}, {"uuid": ["uuid", "5d6e7f80-1a2b-4c3d-8e9f-a0b1c2d3e4f5"]}]`), nil
+		}
+		return nil, nil
+	})
+	cache := ovsdbtest.NewCache(t, f)
+
+	txn := f.Transaction("Open_vSwitch")
+	view := txn.View(cache)
+	bridges := view.GetKeys("Open_vSwitch", "uuid", ovsdbtest.RootId, "bridges")
+	if len(view.GetMap("Bridge", "name", "br1")) == 0 {
+		txn.Insert(dbtransaction.Insert{
+			Table: "Bridge",
+			Row:   ovshelper.Bridge{Name: "br1"},
+		})
+	}
+	_, err, _ := txn.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bridges) != 1 || len(transact) != 4 {
+		t.Fatal("Wrong transaction")
+	}
+	columnGuard, _ := json.Marshal(transact[1])
+	expected := `{"columns":["bridges"],"op":"wait","rows":[{"bridges":["uuid","` + ovsdbtest.BridgeId + `"]}],` +
+		`"table":"Open_vSwitch","timeout":0,"until":"==","where":[["_uuid","==",["uuid","` + ovsdbtest.RootId + `"]]]}`
+	if string(columnGuard) != expected {
+		t.Error("Wrong column guard: " + string(columnGuard))
+	}
+	missingGuard, _ := json.Marshal(transact[2])
+	expected = `{"columns":[],"op":"wait","rows":[],"table":"Bridge","timeout":0,"until":"==","where":[["name","==","br1"]]}`
+	if string(missingGuard) != expected {
+		t.Error("Wrong missing row guard: " + string(missingGuard))
+	}
+}
+
+func TestTransaction_View_KeyGuards(t *testing.T) {
+	var transact []interface{}
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		switch method {
+		case "monitor":
+			return json.RawMessage(ovsdbtest.InitialUpdate), nil
+		case "transact":
+			transact = args
+			return json.RawMessage(`[{}]`), nil
+		}
+		return nil, nil
+	})
+	cache := ovsdbtest.NewCache(t, f)
+
+	txn := f.Transaction("Open_vSwitch")
+	view := txn.View(cache)
+	view.GetKeys("Bridge")
+	bridges := view.GetKeys("Bridge", "uuid")
+	_, err, _ := txn.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only listed row must exist, other inserts to Bridge don't matter
+	if len(bridges) != 1 || len(transact) != 2 {
+		t.Fatal("Wrong transaction")
+	}
+	keyGuard, _ := json.Marshal(transact[1])
+	expected := `{"columns":[],"op":"wait","rows":[{}],"table":"Bridge","timeout":0,"until":"==","where":[["_uuid","==",["uuid","` + ovsdbtest.BridgeId + `"]]]}`
+	if string(keyGuard) != expected {
+		t.Error("Wrong key guard: " + string(keyGuard))
+	}
+}
+
+func TestTransaction_View_ConditionalGuards(t *testing.T) {
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		if method == "monitor_cond" {
+			return json.RawMessage(`{"Bridge": {
+				"` + ovsdbtest.BridgeId + `": {"initial": {"name": "br0", "fail_mode": "secure"}},
+				"` + ovsdbtest.RootId + `": {"initial": {"name": "br1", "fail_mode": "standalone"}}
+			}}`), nil
+		}
+		return nil, nil
+	})
+	cache := &dbcache.Cache{OVSDB: f, Schema: "Open_vSwitch"}
+	err := cache.Start("Open_vSwitch", map[string]dbcache.TableConfig{
+		"Bridge": {
+			Columns: []string{"name", "fail_mode"},
+			Where: dbtransaction.Where(
+				dbtransaction.Equal("fail_mode", "secure"),
+				dbtransaction.Equal("name", "br1"),
+			),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txn := f.Transaction("Open_vSwitch")
+	view := txn.View(cache)
+	view.GetMap("Bridge")
+	view.GetMap("Bridge", "uuid", "5d6e7f80-1a2b-4c3d-8e9f-a0b1c2d3e4f5")
+	explained, err := txn.Explain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var request struct {
+		Params []interface{} `json:"params"`
+	}
+	json.Unmarshal([]byte(explained), &request)
+
+	// cached rows are rows matching any condition, so each one is guarded
+	var guards []string
+	for _, param := range request.Params[1:] {
+		guard := param.(map[string]interface{})
+		columns, _ := json.Marshal(guard["columns"])
+		if string(columns) == `["_uuid"]` || len(guard["rows"].([]interface{})) == 0 {
+			where, _ := json.Marshal(guard["where"])
+			rows, _ := json.Marshal(guard["rows"])
+			guards = append(guards, string(where)+" "+string(rows))
+		}
+	}
+	expected := []string{
+		`[["fail_mode","==","secure"]] [{"_uuid":["uuid","` + ovsdbtest.BridgeId + `"]}]`,
+		`[["name","==","br1"]] [{"_uuid":["uuid","` + ovsdbtest.RootId + `"]}]`,
+		`[["_uuid","==",["uuid","5d6e7f80-1a2b-4c3d-8e9f-a0b1c2d3e4f5"]],["fail_mode","==","secure"]] []`,
+		`[["_uuid","==",["uuid","5d6e7f80-1a2b-4c3d-8e9f-a0b1c2d3e4f5"]],["name","==","br1"]] []`,
+	}
+	if strings.Join(guards, "\n") != strings.Join(expected, "\n") {
+		t.Error("Wrong conditional guards:\n" + strings.Join(guards, "\n"))
+	}
+
+	portsCache := &dbcache.Cache{OVSDB: f, Schema: "Open_vSwitch"}
+	err = portsCache.Start("Open_vSwitch", map[string]dbcache.TableConfig{
+		"Bridge": {
+			Columns: []string{"name", "fail_mode"},
+			Where:   dbtransaction.Where(dbtransaction.Equal("ports", ovshelper.Set{})),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn = f.Transaction("Open_vSwitch")
+	txn.View(portsCache).GetMap("Bridge")
+	if _, err := txn.Explain(); err == nil {
+		t.Error("Guard with condition on not cached column accepted")
+	}
+}
+
+func TestTransaction_DryRun(t *testing.T) {
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		switch method {
+		case "monitor":
+			return json.RawMessage(ovsdbtest.InitialUpdate), nil
+		case "get_schema":
+			return nil, nil
+		}
+		t.Error("Dry run sent " + method)
+		return nil, nil
+	})
+	cache := ovsdbtest.NewCache(t, f)
+
+	txn := f.Transaction("Open_vSwitch")
+	txn.Wait(dbtransaction.Wait{
+		Table:   "Bridge",
+		Where:   dbtransaction.Where(dbtransaction.Equal("name", "br0")),
+		Columns: []string{"external_ids"},
+		Until:   "==",
+		Rows:    []interface{}{map[string]interface{}{"external_ids": ovshelper.Map{"owner": "other"}}},
+	})
+	newId := txn.Insert(dbtransaction.Insert{
+		Table: "Bridge",
+		Row:   ovshelper.Bridge{Name: "br1"},
+	})
+	txn.Mutate(dbtransaction.Mutate{
+		Table: "Open_vSwitch",
+		Mutations: dbtransaction.Mutations(
+			dbtransaction.Add("next_cfg", 1),
+			dbtransaction.InsertToSet("bridges", ovshelper.NamedUUID(newId)),
+			dbtransaction.DeleteUUIDs("bridges", ovsdbtest.BridgeId),
+		),
+	})
+	txn.Delete(dbtransaction.Delete{
+		Table: "Bridge",
+		Where: dbtransaction.Where(dbtransaction.HasUUID(ovsdbtest.BridgeId)),
+	})
+
+	explained, err := txn.Explain()
+	if err != nil || !strings.Contains(explained, `"method": "transact"`) {
+		t.Error("Wrong explain: " + explained)
+	}
+
+	report, err := txn.DryRun(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.FailedWaits) != 1 || report.FailedWaits[0].Op != 0 {
+		t.Error("Failing wait not reported")
+	}
+	if len(report.Inserted) != 1 || report.Inserted[0].New["name"] != "br1" {
+		t.Error("Insert not reported")
+	}
+	if len(report.Mutated) != 1 ||
+		report.Mutated[0].New["next_cfg"] != int64(4) ||
+		!ovshelper.DatumEqual(report.Mutated[0].New["bridges"], ovshelper.UUID(newId)) {
+		t.Error("Mutate not reported: " + report.String())
+	}
+	if len(report.Deleted) != 1 || report.Deleted[0].UUID != ovsdbtest.BridgeId {
+		t.Error("Delete not reported")
+	}
+	if !report.Fails() {
+		t.Error("Failing transaction not detected")
+	}
+}
+
+func TestRowRef(t *testing.T) {
+	var transact []interface{}
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		switch method {
+		case "get_schema":
+			return json.RawMessage(ovsdbtest.Schema), nil
+		case "monitor":
+			return json.RawMessage(ovsdbtest.InitialUpdate), nil
+		case "transact":
+			transact = args
+			return json.RawMessage(`[{}` + strings.Repeat(`, {}`, len(args)-2) + `]`), nil
+		}
+		return nil, nil
+	})
+
+	txn := f.Transaction("Open_vSwitch")
+	txn.Ref("Open_vSwitch", ovsdbtest.RootId).
+		AddChild(dbtransaction.Child{Table: "Bridge", Row: ovshelper.Bridge{Name: "br1"}}).
+		AddChild(dbtransaction.Child{Table: "Port", Row: map[string]interface{}{"name": "p1"}}).
+		AddChild(dbtransaction.Child{Table: "Interface", Row: map[string]interface{}{"name": "p1"}})
+	txn.Ref("Open_vSwitch", ovsdbtest.RootId).
+		RemoveChild(dbtransaction.Child{Table: "Bridge", UUID: ovsdbtest.BridgeId})
+	_, err, _ := txn.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without cache view root row is kept
+	ops := []string{"insert", "mutate", "insert", "mutate", "insert", "mutate", "mutate"}
+	if len(transact) != len(ops)+1 {
+		t.Fatalf("Wrong operation count: %d", len(transact)-1)
+	}
+	for i, op := range ops {
+		action := transact[i+1].(map[string]interface{})
+		if action["op"] != op {
+			t.Errorf("Operation %d is %v, expected %s", i, action["op"], op)
+		}
+	}
+	portLink := transact[4].(map[string]interface{})
+	if portLink["table"] != "Bridge" || portLink["where"].([]interface{})[0].([]interface{})[2].([]interface{})[0] != "named-uuid" {
+		t.Error("Child not linked to inserted parent")
+	}
+
+	cache := &dbcache.Cache{OVSDB: f, Schema: "Open_vSwitch"}
+	err = cache.StartMonitor("Open_vSwitch", map[string][]string{
+		"Open_vSwitch": nil,
+		"Bridge":       nil,
+		"Port":         nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txn = f.Transaction("Open_vSwitch")
+	txn.View(cache)
+	txn.Ref("Open_vSwitch", ovsdbtest.RootId).
+		RemoveChild(dbtransaction.Child{Table: "Bridge", UUID: ovsdbtest.BridgeId})
+	if _, err, _ := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	last := transact[len(transact)-1].(map[string]interface{})
+	if last["op"] != "delete" || last["table"] != "Bridge" {
+		t.Error("Root row without other referrers not deleted:", last["op"])
+	}
+
+	const portId = "6e7f8091-2a3b-4c4d-9e0f-b1c2d3e4f5a6"
+	const ifaceId = "7f8091a2-3b4c-4d5e-8f0a-c2d3e4f5a6b7"
+	const otherIfaceId = "8091a2b3-4c5d-4e6f-9a0b-d3e4f5a6b7c8"
+	f.Update(`{"Port": {"` + portId + `": {"new": {"name": "p0", "interfaces": ["uuid", "` + ifaceId + `"]}}}}`)
+
+	txn = f.Transaction("Open_vSwitch")
+	txn.View(cache)
+	txn.Ref("Port", portId).
+		RemoveChild(dbtransaction.Child{Table: "Interface", UUID: ifaceId})
+	if _, err, _ := txn.Commit(); err == nil {
+		t.Error("Removing last reference of required column not rejected")
+	}
+
+	f.Update(`{"Port": {"` + portId + `": {
+		"old": {"interfaces": ["uuid", "` + ifaceId + `"]},
+		"new": {"name": "p0", "interfaces": ["set", [["uuid", "` + ifaceId + `"], ["uuid", "` + otherIfaceId + `"]]]}
+	}}}`)
+
+	txn = f.Transaction("Open_vSwitch")
+	txn.View(cache)
+	txn.Ref("Port", portId).
+		RemoveChild(dbtransaction.Child{Table: "Interface", UUID: ifaceId})
+	if _, err, _ := txn.Commit(); err != nil {
+		t.Error("Removing one of required references rejected:", err)
+	}
+
+	txn = f.Transaction("Open_vSwitch")
+	txn.Ref("Open_vSwitch", ovsdbtest.RootId).
+		LinkChild(dbtransaction.Child{Table: "Port", UUID: ovsdbtest.BridgeId})
+	if _, err, _ := txn.Commit(); err == nil {
+		t.Error("Link without reference column not rejected")
+	}
+}
+
+func TestReferences_Mutate(t *testing.T) {
+	txn := ovsdbtest.NewFakeOVSDB(nil).Transaction("Open_vSwitch")
+	portId := txn.Insert(dbtransaction.Insert{
+		Table: "Port",
+		Row:   map[string]interface{}{"name": "p1"},
+	})
+	txn.InsertReferences(dbtransaction.InsertReferences{
+		Table:                 "Bridge",
+		WhereId:               ovsdbtest.BridgeId,
+		ReferenceColumn:       "ports",
+		InsertIdsList:         []string{portId},
+		InsertExistingIdsList: []string{ovsdbtest.RootId},
+		Mutate:                true,
+	})
+	txn.DeleteReferences(dbtransaction.DeleteReferences{
+		Table:           "Bridge",
+		WhereId:         ovsdbtest.BridgeId,
+		ReferenceColumn: "ports",
+		DeleteIdsList:   []string{ovsdbtest.RootId},
+		Mutate:          true,
+	})
+
+	explained, err := txn.Explain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var request struct {
+		Params []interface{} `json:"params"`
+	}
+	json.Unmarshal([]byte(explained), &request)
+
+	expected := []string{
+		`[["ports","insert",["set",[["uuid","` + ovsdbtest.RootId + `"],["named-uuid","` + portId + `"]]]]]`,
+		`[["ports","delete",["set",[["uuid","` + ovsdbtest.RootId + `"]]]]]`,
+	}
+	for i, e := range expected {
+		op := request.Params[i+2].(map[string]interface{})
+		mutations, _ := json.Marshal(op["mutations"])
+		if op["op"] != "mutate" || string(mutations) != e {
+			t.Errorf("Wrong references mutation: %v %s", op["op"], mutations)
+		}
+	}
+
+	txn = ovsdbtest.NewFakeOVSDB(nil).Transaction("Open_vSwitch")
+	txn.DeleteReferences(dbtransaction.DeleteReferences{
+		Table:           "Bridge",
+		WhereId:         ovsdbtest.BridgeId,
+		ReferenceColumn: "ports",
+		DeleteIdsList:   []string{ovsdbtest.RootId},
+		CurrentIdsList:  []string{ovsdbtest.RootId},
+		Wait:            true,
+		Mutate:          true,
+	})
+	explained, err = txn.Explain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Params = nil
+	json.Unmarshal([]byte(explained), &request)
+	wait := request.Params[1].(map[string]interface{})
+	rows, _ := json.Marshal(wait["rows"])
+	if wait["op"] != "wait" || string(rows) != `[{"ports":["set",[["uuid","`+ovsdbtest.RootId+`"]]]}]` {
+		t.Errorf("Wrong references wait: %v %s", wait["op"], rows)
+	}
+	if op := request.Params[2].(map[string]interface{}); op["op"] != "mutate" {
+		t.Errorf("Wait not followed by mutate: %v", op["op"])
+	}
+
+	txn = ovsdbtest.NewFakeOVSDB(nil).Transaction("Open_vSwitch")
+	txn.InsertReferences(dbtransaction.InsertReferences{
+		Table:           "Bridge",
+		WhereId:         ovsdbtest.BridgeId,
+		ReferenceColumn: "ports",
+		InsertIdsList:   []string{portId},
+		Wait:            true,
+		Mutate:          true,
+	})
+	if _, err := txn.Explain(); err == nil {
+		t.Error("Wait without current ids accepted")
+	}
+}
+
+func TestTransaction_MutationColumns(t *testing.T) {
+	f := ovsdbtest.NewFakeOVSDB(nil)
+
+	invalid := map[string]dbtransaction.Mutation{
+		"Bridge":       dbtransaction.Add("name", 1),
+		"Open_vSwitch": dbtransaction.InsertToSet("next_cfg", 1),
+		"QoS":          dbtransaction.Add("queues", 1),
+		"Queue":        dbtransaction.Subtract("no_such_column", 1),
+	}
+	for table, mutation := range invalid {
+		txn := f.Transaction("Open_vSwitch")
+		txn.Mutate(dbtransaction.Mutate{
+			Table:     table,
+			Mutations: dbtransaction.Mutations(mutation),
+		})
+		if _, err := txn.Explain(); err == nil {
+			t.Errorf("Invalid mutation of %s accepted: %v", table, mutation)
+		}
+	}
+
+	txn := f.Transaction("Open_vSwitch")
+	txn.Mutate(dbtransaction.Mutate{
+		Table: "Bridge",
+		Mutations: dbtransaction.Mutations(
+			dbtransaction.Modulo("flood_vlans", 2),
+			dbtransaction.InsertToMap("external_ids", ovshelper.Map{"owner": "test"}),
+		),
+	})
+	if _, err := txn.Explain(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTransaction_GCCheck(t *testing.T) {
+	var calls []string
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		switch method {
+		case "get_schema":
+			return json.RawMessage(ovsdbtest.Schema), nil
+		case "transact":
+			op := args[1].(map[string]interface{})["op"].(string)
+			calls = append(calls, op)
+			if op == "select" {
+				// port is gone, interface is kept
+				return json.RawMessage(`[{"rows": []}, {"rows": [{"_uuid": ["uuid", "i1"]}]}]`), nil
+			}
+			return json.RawMessage(`[{"uuid": ["uuid", "p1"]}, {"uuid": ["uuid", "i1"]}, {}]`), nil
+		}
+		return nil, nil
+	})
+
+	stage := func(check dbtransaction.GCCheck) *dbtransaction.Transaction {
+		txn := f.Transaction("Open_vSwitch")
+		txn.GCCheck = check
+		txn.Insert(dbtransaction.Insert{
+			Table: "Port",
+			Row:   map[string]interface{}{"name": "p1"},
+		})
+		ifaceId := txn.Insert(dbtransaction.Insert{
+			Table: "Interface",
+			Row:   map[string]interface{}{"name": "p1"},
+		})
+		txn.Mutate(dbtransaction.Mutate{
+			Table:     "Port",
+			Where:     dbtransaction.Where(dbtransaction.Equal("name", "p0")),
+			Mutations: dbtransaction.Mutations(dbtransaction.InsertToSet("interfaces", ovshelper.NamedUUID(ifaceId))),
+		})
+		return txn
+	}
+
+	txn := stage(dbtransaction.GCFail)
+	if _, err, retry := txn.Commit(); err == nil || retry || len(calls) != 0 {
+		t.Error("Unreferenced insert not rejected")
+	}
+
+	txn = stage(dbtransaction.GCWarn)
+	if _, err, _ := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if len(txn.Warnings) != 1 || !strings.Contains(txn.Warnings[0], "inserts Port row") {
+		t.Error("Unreferenced insert not warned:", txn.Warnings)
+	}
+	if len(calls) != 2 || calls[1] != "select" {
+		t.Error("Inserted rows not checked after commit:", calls)
+	}
+	if len(txn.Collected) != 1 || txn.Collected[0].UUID != "p1" || txn.Collected[0].Table != "Port" {
+		t.Error("Collected row not reported:", txn.Collected)
+	}
+}
+
+func TestTransaction_CommitAndWait(t *testing.T) {
+	const newBridgeId = "2b3c4d5e-6f7a-4b8c-9d0e-1f2a3b4c5d6e"
+	var sent [][]interface{}
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		switch method {
+		case "monitor":
+			return json.RawMessage(ovsdbtest.InitialUpdate), nil
+		case "transact":
+			sent = append(sent, args)
+			if len(sent) == 1 {
+				return json.RawMessage(`[{"uuid": ["uuid", "` + newBridgeId + `"]}, {}, {"count": 1}, {"rows": [{"next_cfg": 5}]}]`), nil
+			}
+			return json.RawMessage(`[{"count": 1}, {}, {"count": 1}, {"rows": [{"next_cfg": 6}]}]`), nil
+		}
+		return nil, nil
+	})
+	cache := ovsdbtest.NewCache(t, f)
+	marker := dbtransaction.Marker{Table: "Open_vSwitch", UUID: ovsdbtest.RootId, Column: "next_cfg"}
+
+	txn := f.Transaction("Open_vSwitch")
+	txn.Insert(dbtransaction.Insert{
+		Table: "Bridge",
+		Row:   map[string]interface{}{"name": "br1"},
+	})
+
+	// other writer incremented marker before this transaction, its update
+	// does not end the wait
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		f.Update(`{"Open_vSwitch": {"` + ovsdbtest.RootId + `": {"old": {"next_cfg": 3}, "new": {"next_cfg": 4, "bridges": ["uuid", "` + ovsdbtest.BridgeId + `"]}}}}`)
+		time.Sleep(20 * time.Millisecond)
+		f.Update(`{"Open_vSwitch": {"` + ovsdbtest.RootId + `": {"old": {"next_cfg": 4}, "new": {"next_cfg": 5, "bridges": ["uuid", "` + ovsdbtest.BridgeId + `"]}}},
+			"Bridge": {"` + newBridgeId + `": {"new": {"name": "br1", "ports": ["set", []], "external_ids": ["map", []]}}}}`)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	res, err, _ := txn.CommitAndWait(ctx, cache, marker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || len(txn.Actions) != 1 || cache.Snapshot().Row("Bridge", newBridgeId) == nil {
+		t.Error("Insert not visible after commit")
+	}
+	mutation, _ := json.Marshal(sent[0][3])
+	if !strings.Contains(string(mutation), `"mutations":[["next_cfg","+=",1]]`) {
+		t.Error("Marker not incremented: " + string(mutation))
+	}
+
+	// no update is sent, so committed results come with context error
+	txn = f.Transaction("Open_vSwitch")
+	txn.Delete(dbtransaction.Delete{
+		Table: "Bridge",
+		Where: dbtransaction.Where(dbtransaction.HasUUID(newBridgeId)),
+	})
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	res, err, _ = txn.CommitAndWait(ctx, cache, marker)
+	if err != context.DeadlineExceeded || len(res) != 1 {
+		t.Error("Expected results with deadline error, got", res, err)
+	}
+
+	// marker must be cached
+	txn = f.Transaction("Open_vSwitch")
+	marker.Column = "name"
+	if _, err, _ := txn.CommitAndWait(context.Background(), cache, marker); err == nil || len(sent) != 2 {
+		t.Error("Marker which is not cached accepted")
+	}
+}
+
+func TestTransaction_Results(t *testing.T) {
+	f := ovsdbtest.NewFakeOVSDB(func(method string, args []interface{}) (json.RawMessage, error) {
+		return json.RawMessage(`[{}, {"count": 0}, {"error": "aborted", "details": "aborted by request"}]`), nil
+	})
+	txn := f.Transaction("Open_vSwitch")
+	txn.Comment("rollback")
+	txn.Delete(dbtransaction.Delete{Table: "Bridge", Where: dbtransaction.Where(dbtransaction.Equal("name", "br0"))})
+	txn.Abort()
+
+	res, err, retry := txn.Commit()
+	if err == nil || retry || res != nil {
+		t.Error("Failed transaction returned results:", res, err)
+	}
+	if len(txn.Results) != 3 || txn.Results[1].Op != "delete" || txn.Results[2].Error != "aborted" {
+		t.Error("Results of failed transaction not kept:", txn.Results)
+	}
+}
@@ -0,0 +1,504 @@
+package dbtransaction
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbcache"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+	"sort"
+	"strings"
+)
+
+// Explain returns JSON-RPC request which Commit would send, in indented form.
+// Request id is assigned on commit, so it is not shown.
+func (txn *Transaction) Explain() (string, error) {
+	if txn.err != nil {
+		return "", txn.err
+	}
+
+	guards, err := txn.reads.guards()
+	if err != nil {
+		return "", err
+	}
+
+	args := []interface{}{txn.Schema}
+	args = append(args, guards...)
+	args = append(args, txn.Actions...)
+
+	request := map[string]interface{}{
+		"method": "transact",
+		"params": args,
+	}
+
+	encoded, err := json.MarshalIndent(request, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// RowChange describes row change found by DryRun. Rows inserted without
+// explicit uuid are identified by their uuid-name. Values are in the form
+// returned by ovshelper.ParseDatum.
+type RowChange struct {
+	Op    int // index of operation in Actions
+	Table string
+	UUID  string
+	Old   map[string]interface{} // changed columns before, nil for insert
+	New   map[string]interface{} // changed columns after, nil for delete
+}
+
+// FailedWait describes wait operation which would fail. Guards added by View
+// have Guard set and Op -1.
+type FailedWait struct {
+	Op      int
+	Guard   bool
+	Table   string
+	Details string
+}
+
+type DryRunReport struct {
+	Inserted    []RowChange
+	Updated     []RowChange
+	Mutated     []RowChange
+	Deleted     []RowChange
+	FailedWaits []FailedWait
+	Errors      []string // other reasons why transaction would fail
+}
+
+// Fails tells whether server would reject the transaction.
+func (r *DryRunReport) Fails() bool {
+	return len(r.FailedWaits) > 0 || len(r.Errors) > 0
+}
+
+func (r *DryRunReport) String() string {
+	var b strings.Builder
+	changes := []struct {
+		name string
+		list []RowChange
+	}{
+		{"insert", r.Inserted},
+		{"update", r.Updated},
+		{"mutate", r.Mutated},
+		{"delete", r.Deleted},
+	}
+	for _, c := range changes {
+		for _, change := range c.list {
+			fmt.Fprintf(&b, "#%d %s %s %s", change.Op, c.name, change.Table, change.UUID)
+			for _, column := range ovshelper.SortedKeys(change.New) {
+				if change.Old != nil {
+					fmt.Fprintf(&b, " %s: %v -> %v", column, change.Old[column], change.New[column])
+				} else {
+					fmt.Fprintf(&b, " %s: %v", column, change.New[column])
+				}
+			}
+			b.WriteString("\n")
+		}
+	}
+	for _, w := range r.FailedWaits {
+		if w.Guard {
+			fmt.Fprintf(&b, "guard wait on %s fails: %s\n", w.Table, w.Details)
+		} else {
+			fmt.Fprintf(&b, "#%d wait on %s fails: %s\n", w.Op, w.Table, w.Details)
+		}
+	}
+	for _, e := range r.Errors {
+		b.WriteString("error: " + e + "\n")
+	}
+	return b.String()
+}
+
+// dryRun holds table state while operations are applied.
+type dryRun struct {
+	snapshot *dbcache.Snapshot
+	tables   map[string]map[string]map[string]interface{} // tables[table][uuid][column], OVSDB notation
+	names    map[string]string                            // uuid-name -> row uuid
+	report   *DryRunReport
+}
+
+// DryRun applies transaction to current cache contents without sending
+// anything to server, and reports rows that would change and operations that
+// would fail. Only cached tables and columns can be checked.
+func (txn *Transaction) DryRun(cache *dbcache.Cache) (*DryRunReport, error) {
+	return txn.dryRun(cache.Snapshot())
+}
+
+func (txn *Transaction) dryRun(snapshot *dbcache.Snapshot) (*DryRunReport, error) {
+	if txn.err != nil {
+		return nil, txn.err
+	}
+
+	d := &dryRun{
+		snapshot: snapshot,
+		tables:   make(map[string]map[string]map[string]interface{}),
+		names:    make(map[string]string),
+		report:   &DryRunReport{},
+	}
+
+	guards, err := txn.reads.guards()
+	if err != nil {
+		return nil, err
+	}
+	for _, guard := range guards {
+		action, err := toRaw(guard)
+		if err != nil {
+			return nil, err
+		}
+		d.wait(-1, action.(map[string]interface{}))
+	}
+
+	for idx, a := range txn.Actions {
+		raw, err := toRaw(a)
+		if err != nil {
+			return nil, err
+		}
+		action, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("operation %d is not an object", idx)
+		}
+
+		switch action["op"] {
+		case "insert":
+			d.insert(idx, action)
+		case "update":
+			d.update(idx, action)
+		case "mutate":
+			d.mutate(idx, action)
+		case "delete":
+			d.delete(idx, action)
+		case "wait":
+			d.wait(idx, action)
+		case "abort":
+			d.fail(idx, "aborted by abort operation")
+		}
+	}
+
+	return d.report, nil
+}
+
+// toRaw converts value to OVSDB notation as it would be decoded from JSON
+func toRaw(value interface{}) (interface{}, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	dec := json.NewDecoder(bytes.NewReader(encoded))
+	dec.UseNumber()
+	err = dec.Decode(&raw)
+	return raw, err
+}
+
+// resolveNamed replaces references to rows inserted earlier in transaction
+// with their uuids.
+func (d *dryRun) resolveNamed(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		if len(v) == 2 && v[0] == "named-uuid" {
+			if name, ok := v[1].(string); ok {
+				if uuid, ok := d.names[name]; ok {
+					return []interface{}{"uuid", uuid}
+				}
+			}
+			return v
+		}
+		ret := make([]interface{}, len(v))
+		for i, val := range v {
+			ret[i] = d.resolveNamed(val)
+		}
+		return ret
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			ret[key] = d.resolveNamed(val)
+		}
+		return ret
+	}
+	return value
+}
+
+func (d *dryRun) fail(idx int, message string) {
+	d.report.Errors = append(d.report.Errors, fmt.Sprintf("#%d %s", idx, message))
+}
+
+// table returns working copy of table rows, nil if table is not cached
+func (d *dryRun) table(name string) map[string]map[string]interface{} {
+	if rows, ok := d.tables[name]; ok {
+		return rows
+	}
+	if !d.snapshot.HasTable(name) {
+		return nil
+	}
+	rows := make(map[string]map[string]interface{})
+	for _, uuid := range d.snapshot.RowIds(name) {
+		rows[uuid] = d.snapshot.Row(name, uuid)
+	}
+	d.tables[name] = rows
+	return rows
+}
+
+func (d *dryRun) selectRows(idx int, table string, where interface{}) (map[string]map[string]interface{}, []string) {
+	rows := d.table(table)
+	if rows == nil {
+		d.fail(idx, "table "+table+" is not cached")
+		return nil, nil
+	}
+
+	var conditions [][]interface{}
+	list, _ := d.resolveNamed(where).([]interface{})
+	for _, c := range list {
+		condition, _ := c.([]interface{})
+		conditions = append(conditions, condition)
+	}
+
+	ids := []string{}
+	for uuid, row := range rows {
+		ok, err := dbcache.MatchRow(uuid, row, conditions)
+		if err != nil {
+			d.fail(idx, err.Error())
+			return nil, nil
+		}
+		if ok {
+			ids = append(ids, uuid)
+		}
+	}
+	sort.Strings(ids)
+	return rows, ids
+}
+
+func parseRow(row map[string]interface{}) (map[string]interface{}, error) {
+	ret := make(map[string]interface{}, len(row))
+	for column, raw := range row {
+		datum, err := ovshelper.ParseDatum(raw)
+		if err != nil {
+			return nil, err
+		}
+		ret[column] = datum
+	}
+	return ret, nil
+}
+
+func (d *dryRun) insert(idx int, action map[string]interface{}) {
+	table, _ := action["table"].(string)
+	name, _ := action["uuid-name"].(string)
+	uuid, _ := action["uuid"].(string)
+	if uuid == "" {
+		uuid = name
+	}
+	if name != "" {
+		d.names[name] = uuid
+	}
+
+	row, _ := d.resolveNamed(action["row"]).(map[string]interface{})
+	parsed, err := parseRow(row)
+	if err != nil {
+		d.fail(idx, err.Error())
+		return
+	}
+
+	if rows := d.table(table); rows != nil {
+		if _, ok := rows[uuid]; ok {
+			d.fail(idx, "duplicate uuid "+uuid)
+			return
+		}
+		rows[uuid] = row
+	}
+
+	d.report.Inserted = append(d.report.Inserted, RowChange{Op: idx, Table: table, UUID: uuid, New: parsed})
+}
+
+func (d *dryRun) update(idx int, action map[string]interface{}) {
+	table, _ := action["table"].(string)
+	rows, ids := d.selectRows(idx, table, action["where"])
+	update, _ := d.resolveNamed(action["row"]).(map[string]interface{})
+
+	for _, uuid := range ids {
+		change := RowChange{Op: idx, Table: table, UUID: uuid, Old: map[string]interface{}{}, New: map[string]interface{}{}}
+		for column, raw := range update {
+			newValue, err := ovshelper.ParseDatum(raw)
+			if err != nil {
+				d.fail(idx, err.Error())
+				return
+			}
+			oldValue, _ := ovshelper.ParseDatum(rows[uuid][column])
+			if _, ok := rows[uuid][column]; ok && ovshelper.DatumEqual(oldValue, newValue) {
+				continue
+			}
+			change.Old[column] = oldValue
+			change.New[column] = newValue
+			rows[uuid][column] = raw
+		}
+		if len(change.New) > 0 {
+			d.report.Updated = append(d.report.Updated, change)
+		}
+	}
+}
+
+func (d *dryRun) mutate(idx int, action map[string]interface{}) {
+	table, _ := action["table"].(string)
+	rows, ids := d.selectRows(idx, table, action["where"])
+	mutations, _ := d.resolveNamed(action["mutations"]).([]interface{})
+
+	for _, uuid := range ids {
+		change := RowChange{Op: idx, Table: table, UUID: uuid, Old: map[string]interface{}{}, New: map[string]interface{}{}}
+		for _, m := range mutations {
+			mutation, _ := m.([]interface{})
+			if len(mutation) != 3 {
+				d.fail(idx, fmt.Sprintf("invalid mutation %v", m))
+				return
+			}
+			column, _ := mutation[0].(string)
+			mutator, _ := mutation[1].(string)
+
+			raw, ok := rows[uuid][column]
+			if !ok {
+				d.fail(idx, "column "+column+" is not cached")
+				return
+			}
+			oldValue, err := ovshelper.ParseDatum(raw)
+			if err != nil {
+				d.fail(idx, err.Error())
+				return
+			}
+			value, err := ovshelper.ParseDatum(mutation[2])
+			if err != nil {
+				d.fail(idx, err.Error())
+				return
+			}
+			newValue, err := ovshelper.ApplyMutation(oldValue, mutator, value)
+			if err != nil {
+				d.fail(idx, err.Error())
+				return
+			}
+			newRaw, err := toRaw(newValue)
+			if err != nil {
+				d.fail(idx, err.Error())
+				return
+			}
+
+			if _, ok := change.Old[column]; !ok {
+				change.Old[column] = oldValue
+			}
+			change.New[column] = newValue
+			rows[uuid][column] = newRaw
+		}
+
+		for column := range change.New {
+			if ovshelper.DatumEqual(change.Old[column], change.New[column]) {
+				delete(change.Old, column)
+				delete(change.New, column)
+			}
+		}
+		if len(change.New) > 0 {
+			d.report.Mutated = append(d.report.Mutated, change)
+		}
+	}
+}
+
+func (d *dryRun) delete(idx int, action map[string]interface{}) {
+	table, _ := action["table"].(string)
+	rows, ids := d.selectRows(idx, table, action["where"])
+
+	for _, uuid := range ids {
+		old, err := parseRow(rows[uuid])
+		if err != nil {
+			d.fail(idx, err.Error())
+			return
+		}
+		delete(rows, uuid)
+		d.report.Deleted = append(d.report.Deleted, RowChange{Op: idx, Table: table, UUID: uuid, Old: old})
+	}
+}
+
+func (d *dryRun) wait(idx int, action map[string]interface{}) {
+	table, _ := action["table"].(string)
+	rows, ids := d.selectRows(idx, table, action["where"])
+	if rows == nil {
+		return
+	}
+
+	columns, _ := action["columns"].([]interface{})
+	project := func(uuid string, row map[string]interface{}) (map[string]interface{}, error) {
+		ret := map[string]interface{}{}
+		for _, c := range columns {
+			column, _ := c.(string)
+			if column == "_uuid" {
+				ret[column] = ovshelper.UUID(uuid)
+				continue
+			}
+			raw, ok := row[column]
+			if !ok {
+				return nil, fmt.Errorf("column %s is not cached", column)
+			}
+			datum, err := ovshelper.ParseDatum(raw)
+			if err != nil {
+				return nil, err
+			}
+			ret[column] = datum
+		}
+		return ret, nil
+	}
+
+	actual := []map[string]interface{}{}
+	for _, uuid := range ids {
+		row, err := project(uuid, rows[uuid])
+		if err != nil {
+			d.fail(idx, err.Error())
+			return
+		}
+		actual = append(actual, row)
+	}
+
+	expectedRaw, _ := d.resolveNamed(action["rows"]).([]interface{})
+	expected := []map[string]interface{}{}
+	for _, r := range expectedRaw {
+		row, _ := r.(map[string]interface{})
+		parsed, err := parseRow(row)
+		if err != nil {
+			d.fail(idx, err.Error())
+			return
+		}
+		expected = append(expected, parsed)
+	}
+
+	equal := len(actual) == len(expected)
+	used := make([]bool, len(actual))
+	for _, e := range expected {
+		if !equal {
+			break
+		}
+		found := false
+		for i, a := range actual {
+			if !used[i] && rowsEqual(a, e) {
+				used[i] = true
+				found = true
+				break
+			}
+		}
+		equal = found
+	}
+
+	until, _ := action["until"].(string)
+	if equal != (until == "==") {
+		d.report.FailedWaits = append(d.report.FailedWaits, FailedWait{
+			Op:      idx,
+			Guard:   idx < 0,
+			Table:   table,
+			Details: fmt.Sprintf("rows %v, expected %s %v", actual, until, expected),
+		})
+	}
+}
+
+func rowsEqual(a map[string]interface{}, b map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for column, value := range a {
+		other, ok := b[column]
+		if !ok || !ovshelper.DatumEqual(value, other) {
+			return false
+		}
+	}
+	return true
+}
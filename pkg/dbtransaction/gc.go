@@ -0,0 +1,203 @@
+package dbtransaction
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+	"strings"
+)
+
+// GCCheck selects what Commit does with rows inserted into non-root tables.
+// Server deletes such rows at the end of transaction unless some row holds
+// strong reference to them. Unless checks are ignored, Commit also fills
+// Collected with rows found missing by separate select transaction after
+// commit. That costs another round trip and is best-effort, see collected.
+type GCCheck int
+
+const (
+	GCIgnore GCCheck = iota // no checks, default
+	GCWarn                  // unreferenced inserts are added to Warnings
+	GCFail                  // unreferenced inserts fail Commit before sending
+)
+
+// CollectedRow is a row inserted by transaction and deleted by server garbage
+// collection.
+type CollectedRow struct {
+	Table    string
+	UUID     string
+	UUIDName string
+}
+
+// strongRefs returns uuids and uuid-names strongly referenced by staged
+// inserts, updates and mutations.
+func (txn *Transaction) strongRefs(schema *ovshelper.Schema) (map[string]bool, error) {
+	refs := map[string]bool{}
+
+	add := func(table string, column string, value interface{}) error {
+		def, ok := schema.Tables[table].Columns[column]
+		if !ok || (!def.Type.Key.IsStrongRef() && !def.Type.Value.IsStrongRef()) {
+			return nil
+		}
+		datum, err := ovshelper.ToDatum(value)
+		if err != nil {
+			return err
+		}
+		var atoms []interface{}
+		switch d := datum.(type) {
+		case ovshelper.Map:
+			for key, val := range d {
+				if def.Type.Key.IsStrongRef() {
+					atoms = append(atoms, key)
+				}
+				if def.Type.Value.IsStrongRef() {
+					atoms = append(atoms, val)
+				}
+			}
+		case ovshelper.Set:
+			atoms = d
+		default:
+			atoms = []interface{}{d}
+		}
+		for _, atom := range atoms {
+			switch a := atom.(type) {
+			case ovshelper.UUID:
+				refs[string(a)] = true
+			case ovshelper.NamedUUID:
+				refs[string(a)] = true
+			}
+		}
+		return nil
+	}
+
+	for _, a := range txn.Actions {
+		action := a.(map[string]interface{})
+		table, _ := action["table"].(string)
+		switch action["op"] {
+		case "insert", "update":
+			raw, err := toRaw(action["row"])
+			if err != nil {
+				return nil, err
+			}
+			row, _ := raw.(map[string]interface{})
+			for column, value := range row {
+				if err := add(table, column, value); err != nil {
+					return nil, err
+				}
+			}
+		case "mutate":
+			raw, err := toRaw(action["mutations"])
+			if err != nil {
+				return nil, err
+			}
+			mutations, _ := raw.([]interface{})
+			for _, m := range mutations {
+				mutation, _ := m.([]interface{})
+				if len(mutation) != 3 || mutation[1] != "insert" {
+					continue
+				}
+				column, _ := mutation[0].(string)
+				if err := add(table, column, mutation[2]); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return refs, nil
+}
+
+// checkGC finds rows inserted into non-root tables without strong reference
+// in the same transaction. Reference from update or mutate is trusted, even
+// though its where clause may match no rows.
+func (txn *Transaction) checkGC() error {
+	schema, err := txn.schemaDefinition()
+	if err != nil {
+		return err
+	}
+	refs, err := txn.strongRefs(schema)
+	if err != nil {
+		return err
+	}
+
+	var unreferenced []string
+	for idx, a := range txn.Actions {
+		action := a.(map[string]interface{})
+		if action["op"] != "insert" {
+			continue
+		}
+		table, _ := action["table"].(string)
+		if schema.Tables[table].IsRoot {
+			continue
+		}
+		name, _ := action["uuid-name"].(string)
+		uuid, _ := action["uuid"].(string)
+		if refs[name] || (uuid != "" && refs[uuid]) {
+			continue
+		}
+		unreferenced = append(unreferenced, fmt.Sprintf("operation %d inserts %s row %s which is not referenced", idx, table, name))
+	}
+
+	if len(unreferenced) == 0 {
+		return nil
+	}
+	if txn.GCCheck == GCFail {
+		return errors.New("rows would be garbage collected: " + strings.Join(unreferenced, "; "))
+	}
+	txn.Warnings = append(txn.Warnings, unreferenced...)
+	return nil
+}
+
+// collected selects rows inserted into non-root tables after commit, rows
+// which are gone were removed by garbage collection. Server collects garbage
+// at the end of transaction, so selects in the same transaction would still
+// see the rows and the check needs second transaction. It is not atomic with
+// commit: row deleted by other client meanwhile is reported as collected too,
+// so the report is best-effort.
+func (txn *Transaction) collected(t Transact) ([]CollectedRow, error) {
+	schema, err := txn.schemaDefinition()
+	if err != nil {
+		return nil, err
+	}
+
+	var inserted []CollectedRow
+	args := []interface{}{txn.Schema}
+	for idx, a := range txn.Actions {
+		action := a.(map[string]interface{})
+		if action["op"] != "insert" || idx >= len(t) || len(t[idx].UUID) != 2 {
+			continue
+		}
+		table, _ := action["table"].(string)
+		if schema.Tables[table].IsRoot {
+			continue
+		}
+		name, _ := action["uuid-name"].(string)
+		inserted = append(inserted, CollectedRow{Table: table, UUID: t[idx].UUID[1], UUIDName: name})
+		args = append(args, map[string]interface{}{
+			"op":      "select",
+			"table":   table,
+			"where":   Where(HasUUID(t[idx].UUID[1])),
+			"columns": []string{"_uuid"},
+		})
+	}
+	if len(inserted) == 0 {
+		return nil, nil
+	}
+
+	response, err := txn.OVSDB.Call("transact", args, nil)
+	if err != nil {
+		return nil, err
+	}
+	var res Transact
+	if err := json.Unmarshal(response, &res); err != nil {
+		return nil, err
+	}
+
+	var collected []CollectedRow
+	for idx, row := range inserted {
+		if idx < len(res) && res[idx].Error == "" && len(res[idx].Rows) == 0 {
+			collected = append(collected, row)
+		}
+	}
+	return collected, nil
+}
@@ -0,0 +1,251 @@
+package dbtransaction
+
+import (
+	"errors"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbcache"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+)
+
+// CacheView gives read access to consistent cache snapshot. It has the same
+// getters as dbcache.Cache, plus Row for values in OVSDB notation.
+type CacheView interface {
+	GetKeys(args ...string) []string
+	GetList(args ...string) []interface{}
+	GetMap(args ...string) map[string]interface{}
+	Row(table string, uuid string) map[string]interface{}
+}
+
+// readSet remembers which parts of cache snapshot were read, so they can be
+// guarded with wait operations on commit.
+type readSet struct {
+	snapshot *dbcache.Snapshot
+	tables   map[string]bool                       // tables whose all rows were read
+	rows     map[string]map[string]map[string]bool // rows[table][uuid][column]
+	missing  map[string]map[string]map[string]bool // missing[table][column][value], looked up but not found
+}
+
+func newReadSet(snapshot *dbcache.Snapshot) *readSet {
+	return &readSet{
+		snapshot: snapshot,
+		tables:   make(map[string]bool),
+		rows:     make(map[string]map[string]map[string]bool),
+		missing:  make(map[string]map[string]map[string]bool),
+	}
+}
+
+// addRow records read of row columns, all columns if none are given.
+func (r *readSet) addRow(table string, uuid string, columns ...string) {
+	if len(columns) == 0 {
+		columns = ovshelper.SortedKeys(r.snapshot.Row(table, uuid))
+	}
+	if r.rows[table] == nil {
+		r.rows[table] = make(map[string]map[string]bool)
+	}
+	if r.rows[table][uuid] == nil {
+		r.rows[table][uuid] = make(map[string]bool)
+	}
+	for _, column := range columns {
+		r.rows[table][uuid][column] = true
+	}
+}
+
+func (r *readSet) addMissing(table string, column string, value string) {
+	if r.missing[table] == nil {
+		r.missing[table] = make(map[string]map[string]bool)
+	}
+	if r.missing[table][column] == nil {
+		r.missing[table][column] = make(map[string]bool)
+	}
+	r.missing[table][column][value] = true
+}
+
+// record registers read of cache path Data[table][index_type][index_val]...
+// rows says whether row contents were returned or only keys.
+func (r *readSet) record(rows bool, args ...string) {
+	if len(args) == 0 {
+		for _, table := range r.snapshot.GetKeys() {
+			r.record(rows, table)
+		}
+		return
+	}
+
+	table := args[0]
+	if len(args) < 3 && rows {
+		// all rows were read, so rows inserted meanwhile change the result
+		r.tables[table] = true
+		for _, uuid := range r.snapshot.RowIds(table) {
+			r.addRow(table, uuid)
+		}
+		return
+	}
+	if len(args) == 1 {
+		// only index names were read
+		return
+	}
+	if len(args) == 2 {
+		// listed keys must stay, rows inserted meanwhile are not guarded so
+		// unrelated inserts don't fail the transaction
+		column := args[1]
+		if column == "uuid" {
+			column = "_uuid"
+		}
+		for _, uuid := range r.snapshot.RowIds(table) {
+			r.addRow(table, uuid, column)
+		}
+		return
+	}
+
+	index, value := args[1], args[2]
+	uuid := value
+	if index != "uuid" {
+		uuid, _ = r.snapshot.GetMap(table, index, value)["uuid"].(string)
+	}
+	if uuid != "" && r.snapshot.Row(table, uuid) != nil {
+		switch {
+		case len(args) == 3 && !rows:
+			// only column names were read
+		case len(args) == 3:
+			r.addRow(table, uuid)
+		default:
+			r.addRow(table, uuid, args[3])
+		}
+		// row was found by index column value, which must stay the same
+		if index != "uuid" {
+			r.addRow(table, uuid, index)
+		}
+	} else if index == "uuid" {
+		r.addMissing(table, "_uuid", value)
+	} else {
+		r.addMissing(table, index, value)
+	}
+}
+
+// guards returns wait operations which fail if any recorded read would give
+// different result now. Tables of conditional caches hold only rows matching
+// any of table conditions, so reads of whole tables and missing rows are
+// guarded for each condition separately.
+func (r *readSet) guards() ([]interface{}, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	actions := []interface{}{}
+
+	for _, table := range ovshelper.SortedKeys(r.tables) {
+		for _, where := range r.conditions(table) {
+			ids, err := r.snapshot.Select(table, where)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("can not guard rows of %s selected by %v: %v", table, where, err))
+			}
+			rows := make([]interface{}, len(ids))
+			for i, uuid := range ids {
+				rows[i] = map[string]interface{}{"_uuid": []string{"uuid", uuid}}
+			}
+			actions = append(actions, waitAction(Wait{
+				Table:   table,
+				Where:   where,
+				Columns: []string{"_uuid"},
+				Until:   "==",
+				Rows:    rows,
+			}))
+		}
+	}
+
+	for _, table := range ovshelper.SortedKeys(r.rows) {
+		for _, uuid := range ovshelper.SortedKeys(r.rows[table]) {
+			row := r.snapshot.Row(table, uuid)
+			guarded := map[string]interface{}{}
+			for column := range r.rows[table][uuid] {
+				if value, ok := row[column]; ok {
+					guarded[column] = value
+				}
+			}
+			// with no columns to compare row must still exist
+			actions = append(actions, waitAction(Wait{
+				Table:   table,
+				Where:   Where(HasUUID(uuid)),
+				Columns: ovshelper.SortedKeys(guarded),
+				Until:   "==",
+				Rows:    []interface{}{guarded},
+			}))
+		}
+	}
+
+	for _, table := range ovshelper.SortedKeys(r.missing) {
+		for _, column := range ovshelper.SortedKeys(r.missing[table]) {
+			for _, value := range ovshelper.SortedKeys(r.missing[table][column]) {
+				var lookup Condition
+				if column == "_uuid" {
+					lookup = HasUUID(value)
+				} else {
+					lookup = Equal(column, value)
+				}
+				for _, where := range r.conditions(table) {
+					actions = append(actions, waitAction(Wait{
+						Table:   table,
+						Where:   append(Where(lookup), where...),
+						Columns: []string{},
+						Until:   "==",
+						Rows:    []interface{}{},
+					}))
+				}
+			}
+		}
+	}
+
+	return actions, nil
+}
+
+// conditions returns where clauses which together select cached rows of
+// table, a single empty clause if all rows are cached.
+func (r *readSet) conditions(table string) [][][]interface{} {
+	conditions := r.snapshot.Conditions(table)
+	if len(conditions) == 0 {
+		return [][][]interface{}{{}}
+	}
+	clauses := make([][][]interface{}, len(conditions))
+	for i, condition := range conditions {
+		clauses[i] = [][]interface{}{condition}
+	}
+	return clauses
+}
+
+// guardedView records reads to transaction read set.
+type guardedView struct {
+	reads *readSet
+}
+
+// View returns cache snapshot taken on first call. Rows and columns read
+// through it are remembered, and Commit adds wait operations which fail the
+// transaction (with retry) if any of the read values were changed meanwhile.
+// This gives serializable read-modify-write without manual WaitRows. Keys
+// listed with GetKeys(table, index) guard only the listed rows, rows inserted
+// meanwhile don't fail the transaction; read whole table with GetList or
+// GetMap to guard against inserts too.
+func (txn *Transaction) View(cache *dbcache.Cache) CacheView {
+	if txn.reads == nil {
+		txn.reads = newReadSet(cache.Snapshot())
+	}
+	return &guardedView{reads: txn.reads}
+}
+
+func (v *guardedView) GetKeys(args ...string) []string {
+	v.reads.record(false, args...)
+	return v.reads.snapshot.GetKeys(args...)
+}
+
+func (v *guardedView) GetList(args ...string) []interface{} {
+	v.reads.record(true, args...)
+	return v.reads.snapshot.GetList(args...)
+}
+
+func (v *guardedView) GetMap(args ...string) map[string]interface{} {
+	v.reads.record(true, args...)
+	return v.reads.snapshot.GetMap(args...)
+}
+
+func (v *guardedView) Row(table string, uuid string) map[string]interface{} {
+	v.reads.record(true, table, "uuid", uuid)
+	return v.reads.snapshot.Row(table, uuid)
+}
@@ -0,0 +1,216 @@
+package dbtransaction
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+	"reflect"
+)
+
+// Mutation is a single RFC 7047 mutation: [column, mutator, value].
+// Mutations are combined with Mutations and passed to Mutate.
+type Mutation []interface{}
+
+// Mutations combines mutations for Mutate.
+func Mutations(mutations ...Mutation) [][]interface{} {
+	list := make([][]interface{}, len(mutations))
+	for i, m := range mutations {
+		list[i] = []interface{}(m)
+	}
+	return list
+}
+
+// Add, Subtract, Multiply, Divide and Modulo apply arithmetic to integer and
+// real columns, or to every element of integer and real set columns.
+func Add(column string, value interface{}) Mutation {
+	return Mutation{column, "+=", value}
+}
+
+func Subtract(column string, value interface{}) Mutation {
+	return Mutation{column, "-=", value}
+}
+
+func Multiply(column string, value interface{}) Mutation {
+	return Mutation{column, "*=", value}
+}
+
+func Divide(column string, value interface{}) Mutation {
+	return Mutation{column, "/=", value}
+}
+
+// Modulo is only valid for integer columns.
+func Modulo(column string, value interface{}) Mutation {
+	return Mutation{column, "%=", value}
+}
+
+// InsertToSet adds elements to set column.
+func InsertToSet(column string, elements ...interface{}) Mutation {
+	return Mutation{column, "insert", ovshelper.Set(elements)}
+}
+
+// DeleteFromSet removes elements from set column.
+func DeleteFromSet(column string, elements ...interface{}) Mutation {
+	return Mutation{column, "delete", ovshelper.Set(elements)}
+}
+
+// InsertUUIDs adds row references to set column.
+func InsertUUIDs(column string, uuids ...string) Mutation {
+	elements := make([]interface{}, len(uuids))
+	for i, uuid := range uuids {
+		elements[i] = ovshelper.UUID(uuid)
+	}
+	return InsertToSet(column, elements...)
+}
+
+// DeleteUUIDs removes row references from set column.
+func DeleteUUIDs(column string, uuids ...string) Mutation {
+	elements := make([]interface{}, len(uuids))
+	for i, uuid := range uuids {
+		elements[i] = ovshelper.UUID(uuid)
+	}
+	return DeleteFromSet(column, elements...)
+}
+
+// InsertToMap adds pairs to map column. Keys that already exist keep their
+// old values.
+func InsertToMap(column string, pairs ovshelper.Map) Mutation {
+	return Mutation{column, "insert", pairs}
+}
+
+// DeleteFromMap removes pairs from map column, but only where both key and
+// value match.
+func DeleteFromMap(column string, pairs ovshelper.Map) Mutation {
+	return Mutation{column, "delete", pairs}
+}
+
+// DeleteKeys removes pairs with given keys from map column regardless of
+// their values.
+func DeleteKeys(column string, keys ...interface{}) Mutation {
+	return Mutation{column, "delete", ovshelper.Set(keys)}
+}
+
+// validateMutations checks mutators and operand types, so invalid
+// combinations are reported before the transaction is sent to the server.
+func validateMutations(mutations [][]interface{}) error {
+	for _, m := range mutations {
+		if len(m) != 3 {
+			return errors.New(fmt.Sprintf("mutation must have 3 elements: %v", m))
+		}
+		column, ok := m[0].(string)
+		if !ok || column == "" {
+			return errors.New(fmt.Sprintf("mutation column must be non empty string: %v", m))
+		}
+		mutator, ok := m[1].(string)
+		if !ok {
+			return errors.New(fmt.Sprintf("unknown mutator: %v", m[1]))
+		}
+
+		switch mutator {
+		case "+=", "-=", "*=":
+			if !isNumber(m[2]) {
+				return errors.New(fmt.Sprintf("mutator %s requires integer or real value: %v", mutator, m))
+			}
+		case "/=", "%=":
+			if mutator == "%=" && !isInteger(m[2]) {
+				return errors.New(fmt.Sprintf("mutator %s requires integer value: %v", mutator, m))
+			}
+			if !isNumber(m[2]) {
+				return errors.New(fmt.Sprintf("mutator %s requires integer or real value: %v", mutator, m))
+			}
+			if isZero(m[2]) {
+				return errors.New(fmt.Sprintf("mutator %s with zero value: %v", mutator, m))
+			}
+		case "insert", "delete":
+			if !isSetOrMap(m[2]) {
+				return errors.New(fmt.Sprintf("mutator %s requires set, map or atom value: %v", mutator, m))
+			}
+		default:
+			return errors.New(fmt.Sprintf("unknown mutator: %v", m[1]))
+		}
+	}
+	return nil
+}
+
+// validateMutationColumns checks mutators against column types of parsed
+// schema. When schema can not be fetched, mutations are left for the server
+// to check.
+func (txn *Transaction) validateMutationColumns(table string, mutations [][]interface{}) error {
+	schema, err := txn.schemaDefinition()
+	if err != nil {
+		return nil
+	}
+	tableSchema, ok := schema.Tables[table]
+	if !ok {
+		return errors.New(fmt.Sprintf("unknown table %s", table))
+	}
+
+	for _, m := range mutations {
+		column, mutator := m[0].(string), m[1].(string)
+		columnSchema, ok := tableSchema.Columns[column]
+		if !ok {
+			return errors.New(fmt.Sprintf("unknown column %s in table %s", column, table))
+		}
+		columnType := columnSchema.Type
+
+		switch mutator {
+		case "+=", "-=", "*=", "/=", "%=":
+			keyType := columnType.Key.Type
+			if columnType.IsMap() || (keyType != "integer" && keyType != "real") {
+				return errors.New(fmt.Sprintf("mutator %s requires integer or real column: %v", mutator, m))
+			}
+			if keyType == "integer" && !isInteger(m[2]) {
+				return errors.New(fmt.Sprintf("mutator %s on integer column requires integer value: %v", mutator, m))
+			}
+			if mutator == "%=" && keyType != "integer" {
+				return errors.New(fmt.Sprintf("mutator %s requires integer column: %v", mutator, m))
+			}
+		case "insert", "delete":
+			if !columnType.IsSet() && !columnType.IsMap() {
+				return errors.New(fmt.Sprintf("mutator %s requires set or map column: %v", mutator, m))
+			}
+		}
+	}
+	return nil
+}
+
+func isInteger(value interface{}) bool {
+	switch v := value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return true
+	case json.Number:
+		_, err := v.Int64()
+		return err == nil
+	}
+	return false
+}
+
+func isZero(value interface{}) bool {
+	if n, ok := value.(json.Number); ok {
+		f, err := n.Float64()
+		return err == nil && f == 0
+	}
+	return reflect.ValueOf(value).IsZero()
+}
+
+// isSetOrMap accepts typed and raw sets and maps, and single atoms which
+// OVSDB treats as one element sets.
+func isSetOrMap(value interface{}) bool {
+	switch v := value.(type) {
+	case ovshelper.Set, ovshelper.Map, ovshelper.UUID, ovshelper.NamedUUID, string, bool:
+		return true
+	case []interface{}:
+		if len(v) == 2 {
+			switch v[0] {
+			case "set", "map", "uuid", "named-uuid":
+				return true
+			}
+		}
+		return false
+	case UUID:
+		return len(v) == 2 && (v[0] == "uuid" || v[0] == "named-uuid")
+	case []string:
+		return len(v) == 2 && (v[0] == "uuid" || v[0] == "named-uuid")
+	}
+	return isNumber(value)
+}
@@ -0,0 +1,241 @@
+package dbtransaction
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbcache"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
+)
+
+type schemaProvider interface {
+	GetParsedSchema(string) (*ovshelper.Schema, error)
+}
+
+// schemaDefinition returns parsed schema of transaction database. It is
+// fetched from server on first use.
+func (txn *Transaction) schemaDefinition() (*ovshelper.Schema, error) {
+	if txn.schemaDef != nil {
+		return txn.schemaDef, nil
+	}
+
+	if provider, ok := txn.OVSDB.(schemaProvider); ok {
+		schema, err := provider.GetParsedSchema(txn.Schema)
+		if err != nil {
+			return nil, err
+		}
+		txn.schemaDef = schema
+		return schema, nil
+	}
+
+	response, err := txn.OVSDB.Call("get_schema", []string{txn.Schema}, nil)
+	if err != nil {
+		return nil, err
+	}
+	schema := new(ovshelper.Schema)
+	if err := json.Unmarshal(response, schema); err != nil {
+		return nil, err
+	}
+	txn.schemaDef = schema
+	return schema, nil
+}
+
+// RowRef refers to existing row, or to row inserted earlier in the same
+// transaction, and manages references from it to child rows.
+type RowRef struct {
+	txn   *Transaction
+	Table string
+	UUID  string // row uuid, or uuid-name if Named is set
+	Named bool
+}
+
+// Ref returns reference to existing row.
+func (txn *Transaction) Ref(table string, uuid string) *RowRef {
+	return &RowRef{txn: txn, Table: table, UUID: uuid}
+}
+
+// NamedRef returns reference to row inserted earlier in the same transaction,
+// uuidName is value returned by Insert.
+func (txn *Transaction) NamedRef(table string, uuidName string) *RowRef {
+	return &RowRef{txn: txn, Table: table, UUID: uuidName, Named: true}
+}
+
+func (ref *RowRef) where() [][]interface{} {
+	if ref.Named {
+		return Where(HasNamedUUID(ref.UUID))
+	}
+	return Where(HasUUID(ref.UUID))
+}
+
+func (ref *RowRef) value() interface{} {
+	if ref.Named {
+		return ovshelper.NamedUUID(ref.UUID)
+	}
+	return ovshelper.UUID(ref.UUID)
+}
+
+// Child describes child row for RowRef methods.
+type Child struct {
+	Table  string      // child table
+	Row    interface{} // row to insert, used by AddChild
+	UUID   string      // existing child row for LinkChild and RemoveChild, optional uuid of new row for AddChild
+	Column string      // parent column referring to child table, found from schema if empty
+}
+
+// referenceColumn finds parent column referring to child table.
+func (ref *RowRef) referenceColumn(c Child) (string, ovshelper.Column, error) {
+	schema, err := ref.txn.schemaDefinition()
+	if err != nil {
+		return "", ovshelper.Column{}, err
+	}
+
+	name := c.Column
+	if name == "" {
+		columns := schema.References(ref.Table, c.Table)
+		if len(columns) != 1 {
+			return "", ovshelper.Column{}, errors.New(fmt.Sprintf("%s has %d columns referring to %s, column must be given", ref.Table, len(columns), c.Table))
+		}
+		name = columns[0]
+	}
+
+	column, ok := schema.Tables[ref.Table].Columns[name]
+	if !ok {
+		return "", ovshelper.Column{}, errors.New(fmt.Sprintf("unknown column %s.%s", ref.Table, name))
+	}
+	if column.Type.IsMap() || column.Type.Key.RefTable != c.Table {
+		return "", ovshelper.Column{}, errors.New(fmt.Sprintf("%s.%s is not a set of references to %s", ref.Table, name, c.Table))
+	}
+	return name, column, nil
+}
+
+// link adds reference to child. Sets are mutated, so concurrent writers
+// adding other children do not overwrite each other.
+func (ref *RowRef) link(c Child, child interface{}) {
+	name, column, err := ref.referenceColumn(c)
+	if err != nil {
+		ref.txn.setError(err)
+		return
+	}
+
+	if column.Type.Max == 1 {
+		ref.txn.Update(Update{
+			Table: ref.Table,
+			Where: ref.where(),
+			Row:   map[string]interface{}{name: child},
+		})
+		return
+	}
+
+	ref.txn.Mutate(Mutate{
+		Table:     ref.Table,
+		Where:     ref.where(),
+		Mutations: Mutations(InsertToSet(name, child)),
+	})
+}
+
+// AddChild inserts child row, adds reference to it in parent and returns
+// reference to the new row. If c.UUID is set, new row gets that uuid. Whole
+// trees can be built by chaining:
+//
+//	txn.Ref("Open_vSwitch", rootId).
+//		AddChild(Child{Table: "Bridge", Row: bridge}).
+//		AddChild(Child{Table: "Port", Row: port}).
+//		AddChild(Child{Table: "Interface", Row: iface})
+func (ref *RowRef) AddChild(c Child) *RowRef {
+	uuidName := ref.txn.Insert(Insert{
+		Table: c.Table,
+		Row:   c.Row,
+		UUID:  c.UUID,
+	})
+	child := ref.txn.NamedRef(c.Table, uuidName)
+
+	ref.link(c, child.value())
+
+	return child
+}
+
+// LinkChild adds reference to existing child row and returns parent reference.
+func (ref *RowRef) LinkChild(c Child) *RowRef {
+	ref.link(c, ovshelper.UUID(c.UUID))
+	return ref
+}
+
+// RemoveChild removes reference to child row and returns parent reference.
+// Rows of non-root tables are deleted by server when last strong reference
+// is removed. Rows of root tables are deleted only when cache View of the
+// transaction shows no other rows referring to them, otherwise they are kept.
+// If parent row is in View, removing reference which would leave column with
+// less than minimum number of elements is rejected, otherwise server checks
+// it on commit.
+func (ref *RowRef) RemoveChild(c Child) *RowRef {
+	name, column, err := ref.referenceColumn(c)
+	if err != nil {
+		ref.txn.setError(err)
+		return ref
+	}
+
+	schema, err := ref.txn.schemaDefinition()
+	if err != nil {
+		ref.txn.setError(err)
+		return ref
+	}
+
+	if left, ok := ref.remaining(name, c.UUID); ok && left < column.Type.Min {
+		ref.txn.setError(errors.New(fmt.Sprintf("%s.%s can not have less than %d elements, replace reference instead", ref.Table, name, column.Type.Min)))
+		return ref
+	}
+
+	ref.txn.Mutate(Mutate{
+		Table:     ref.Table,
+		Where:     ref.where(),
+		Mutations: Mutations(DeleteUUIDs(name, c.UUID)),
+	})
+
+	if schema.Tables[c.Table].IsRoot && ref.onlyReferrer(name, c) {
+		ref.txn.Delete(Delete{
+			Table: c.Table,
+			Where: Where(HasUUID(c.UUID)),
+		})
+	}
+
+	return ref
+}
+
+// remaining returns number of references left in parent column after
+// reference to uuid is removed. It is false when parent row is not in cache
+// View of the transaction. Parent row is guarded like other View reads.
+func (ref *RowRef) remaining(column string, uuid string) (int, bool) {
+	if ref.txn.reads == nil || ref.Named {
+		return 0, false
+	}
+	row := (&guardedView{reads: ref.txn.reads}).Row(ref.Table, ref.UUID)
+	if row == nil {
+		return 0, false
+	}
+	datum, err := ovshelper.ParseDatum(row[column])
+	if err != nil {
+		return 0, false
+	}
+
+	set, ok := datum.(ovshelper.Set)
+	if !ok {
+		set = ovshelper.Set{datum}
+	}
+	left := 0
+	for _, atom := range set {
+		if atom != ovshelper.UUID(uuid) {
+			left++
+		}
+	}
+	return left, true
+}
+
+// onlyReferrer tells whether parent column is the only reference to child
+// in cache View of the transaction.
+func (ref *RowRef) onlyReferrer(column string, c Child) bool {
+	if ref.txn.reads == nil || ref.Named {
+		return false
+	}
+	referrers := ref.txn.reads.snapshot.Referrers(c.Table, c.UUID)
+	return len(referrers) == 1 && referrers[0] == dbcache.Referrer{Table: ref.Table, Column: column, UUID: ref.UUID}
+}
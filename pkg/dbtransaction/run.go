@@ -0,0 +1,56 @@
+package dbtransaction
+
+import (
+	"context"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbcache"
+	"time"
+)
+
+const (
+	retryInitialDelay = 10 * time.Millisecond
+	retryMaxDelay     = time.Second
+)
+
+// RunTransaction builds transaction with fn from consistent cache snapshot and
+// commits it. Values read through view are guarded as described in View.
+// Failed guards, wait timeouts and connection errors are retried with backoff
+// until context expires. Nothing is sent once context is done. Error returned
+// by fn stops retrying and is returned as is.
+func RunTransaction(ctx context.Context, ovsdb iOVSDB, cache *dbcache.Cache, fn func(*Transaction, CacheView) error) (Transact, error) {
+	delay := retryInitialDelay
+	for {
+		// Commit does not know context, so done context must not send
+		// another attempt
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		txn := &Transaction{
+			OVSDB:      ovsdb,
+			Schema:     cache.Schema,
+			Tables:     map[string]string{},
+			References: make(map[string][]interface{}),
+			Counter:    1,
+		}
+
+		if err := fn(txn, txn.View(cache)); err != nil {
+			return nil, err
+		}
+
+		res, err, retry := txn.Commit()
+		if err == nil || !retry {
+			return res, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package dbtransaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbcache"
+)
+
+// Marker is an integer column of cached row, which CommitAndWait increments
+// to recognize monitor update of transaction, for example next_cfg of
+// Open_vSwitch root row. Other writers may increment it too, but must not
+// decrease it.
+type Marker struct {
+	Table  string
+	UUID   string
+	Column string
+}
+
+// value returns marker value in snapshot, ok is false if row is not cached.
+func (m Marker) value(snapshot *dbcache.Snapshot) (int64, bool) {
+	row, err := snapshot.TypedRow(m.Table, m.UUID)
+	if err != nil || row == nil {
+		return 0, false
+	}
+	value, ok := row[m.Column].(int64)
+	return value, ok
+}
+
+// markerResult returns marker value selected by the last of marker
+// operations following staged ones.
+func markerResult(t Transact, staged int, column string) (int64, error) {
+	if len(t) != staged+3 || len(t[len(t)-1].Rows) != 1 {
+		return 0, errors.New("marker row not selected")
+	}
+	row, _ := t[len(t)-1].Rows[0].(map[string]interface{})
+	value, ok := row[column].(float64)
+	if !ok {
+		return 0, errors.New(fmt.Sprintf("marker column %s is not integer", column))
+	}
+	return int64(value), nil
+}
+
+// CommitAndWait commits transaction like Commit and, if it succeeds, blocks
+// until cache has applied it, so following cache reads see changes made by
+// transaction. Marker is incremented by the same transaction and its new
+// value is selected. Server sends monitor updates in commit order, so once
+// cached marker reaches that value, cache has applied the transaction, no
+// matter what other writers did meanwhile. Marker column must be monitored
+// by cache. Results of marker operations are not returned.
+//
+// If cache does not see changes before context is done, results of committed
+// transaction are returned with ctx.Err().
+func (txn *Transaction) CommitAndWait(ctx context.Context, cache *dbcache.Cache, marker Marker) (Transact, error, bool) {
+	if txn.err != nil {
+		return nil, txn.err, false
+	}
+	if _, ok := marker.value(cache.Snapshot()); !ok {
+		return nil, errors.New(fmt.Sprintf("marker %s.%s of row %s is not cached integer column", marker.Table, marker.Column, marker.UUID)), false
+	}
+
+	// marker row must exist, otherwise transaction fails without changes
+	staged := len(txn.Actions)
+	where := Where(HasUUID(marker.UUID))
+	txn.Wait(Wait{
+		Table:   marker.Table,
+		Where:   where,
+		Columns: []string{},
+		Until:   "==",
+		Rows:    []interface{}{map[string]interface{}{}},
+	})
+	txn.Mutate(Mutate{
+		Table:     marker.Table,
+		Where:     where,
+		Mutations: Mutations(Add(marker.Column, 1)),
+	})
+	txn.Select(Select{
+		Table:   marker.Table,
+		Where:   where,
+		Columns: []string{marker.Column},
+	})
+
+	t, err, retry := txn.Commit()
+	txn.Actions = txn.Actions[:staged]
+	if len(txn.Results) > staged {
+		txn.Results = txn.Results[:staged]
+	}
+	if err != nil {
+		return nil, err, retry
+	}
+	target, err := markerResult(t, staged, marker.Column)
+	if err != nil {
+		return t[:min(staged, len(t))], err, false
+	}
+	t = t[:staged]
+
+	err = cache.WaitFor(ctx, func(snapshot *dbcache.Snapshot) bool {
+		// row deleted after the transaction means it was applied
+		value, ok := marker.value(snapshot)
+		return !ok || value >= target
+	})
+	if err != nil {
+		return t, err, false
+	}
+	return t, nil, false
+}
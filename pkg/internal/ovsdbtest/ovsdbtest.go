@@ -0,0 +1,232 @@
+// Package ovsdbtest has fake OVSDB handle and fixtures shared by tests of
+// client side logic, so it can be tested without ovsdb-server.
+package ovsdbtest
+
+import (
+	"encoding/json"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbcache"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbmonitor"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbtransaction"
+	"sort"
+	"sync"
+	"testing"
+)
+
+const RootId = "0c4d8e7a-9b1f-4a2c-8d3e-5f6a7b8c9d0e"
+const BridgeId = "1a2b3c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d"
+
+// InitialUpdate is monitor reply with root row and one bridge.
+var InitialUpdate = `{
+	"Open_vSwitch": {"` + RootId + `": {"new": {"next_cfg": 3, "bridges": ["uuid", "` + BridgeId + `"]}}},
+	"Bridge": {"` + BridgeId + `": {"new": {"name": "br0", "ports": ["set", []], "external_ids": ["map", [["owner", "test"]]]}}}
+}`
+
+// Schema is part of Open_vSwitch schema used by fake, get_schema returns it
+// unless handler answers.
+const Schema = `{
+	"name": "Open_vSwitch",
+	"version": "1.0.0",
+	"tables": {
+		"Open_vSwitch": {"isRoot": true, "maxRows": 1, "columns": {
+			"next_cfg": {"type": "integer"},
+			"bridges": {"type": {"key": {"type": "uuid", "refTable": "Bridge"}, "min": 0, "max": "unlimited"}}}},
+		"Bridge": {"isRoot": true, "indexes": [["name"]], "columns": {
+			"name": {"type": "string"},
+			"fail_mode": {"type": {"key": {"type": "string", "enum": ["set", ["standalone", "secure"]]}, "min": 0, "max": 1}},
+			"flood_vlans": {"type": {"key": {"type": "integer", "minInteger": 0, "maxInteger": 4095}, "min": 0, "max": 4096}},
+			"external_ids": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}},
+			"ports": {"type": {"key": {"type": "uuid", "refTable": "Port"}, "min": 0, "max": "unlimited"}}}},
+		"Port": {"columns": {
+			"name": {"type": "string"},
+			"interfaces": {"type": {"key": {"type": "uuid", "refTable": "Interface"}, "min": 1, "max": "unlimited"}}}},
+		"Interface": {"columns": {
+			"name": {"type": "string"}}},
+		"QoS": {"isRoot": true, "columns": {
+			"queues": {"type": {"key": {"type": "integer", "minInteger": 0, "maxInteger": 4294967295}, "value": {"type": "uuid", "refTable": "Queue"}, "min": 0, "max": "unlimited"}}}},
+		"Queue": {"isRoot": true, "columns": {
+			"dscp": {"type": {"key": {"type": "integer", "minInteger": 0, "maxInteger": 63}, "min": 0, "max": 1}}}}
+	}
+}`
+
+// Bridge is model of Bridge rows in Schema.
+type Bridge struct {
+	UUID        string            `ovsdb:"_uuid"`
+	Name        string            `ovsdb:"name"`
+	Ports       []string          `ovsdb:"ports"`
+	ExternalIds map[string]string `ovsdb:"external_ids"`
+	FailMode    *string           `ovsdb:"fail_mode"`
+}
+
+// FakeOVSDB answers calls with Handler instead of server. Callbacks of
+// monitors are kept like by client, so tests can send notifications.
+// Notifications are passed to callbacks with callbacks locked, so callbacks
+// which wait for code registering callbacks deadlock like with real
+// connection reader.
+type FakeOVSDB struct {
+	Handler           func(method string, args []interface{}) (json.RawMessage, error)
+	mutex             sync.Mutex
+	callbacks         map[string]dbmonitor.Callback
+	counter           uint64
+	closeCallbacks    map[string]func()
+	canceledCallbacks map[string]func()
+}
+
+func NewFakeOVSDB(handler func(string, []interface{}) (json.RawMessage, error)) *FakeOVSDB {
+	return &FakeOVSDB{
+		callbacks:         make(map[string]dbmonitor.Callback),
+		Handler:           handler,
+		closeCallbacks:    make(map[string]func()),
+		canceledCallbacks: make(map[string]func()),
+	}
+}
+
+func (f *FakeOVSDB) Call(method string, args interface{}, idref *uint64) (json.RawMessage, error) {
+	var list []interface{}
+	encoded, _ := json.Marshal(args)
+	json.Unmarshal(encoded, &list)
+	var response json.RawMessage
+	var err error
+	if f.Handler != nil {
+		response, err = f.Handler(method, list)
+	}
+	// schema is needed by cache and transactions, tests don't have to provide it
+	if method == "get_schema" && response == nil && err == nil {
+		return json.RawMessage(Schema), nil
+	}
+	return response, err
+}
+
+func (f *FakeOVSDB) Notify(method string, args interface{}) error {
+	return nil
+}
+
+func (f *FakeOVSDB) AddCallBack(id string, callback dbmonitor.Callback) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.callbacks[id] = callback
+}
+
+func (f *FakeOVSDB) RemoveCallBack(id string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.callbacks, id)
+	delete(f.canceledCallbacks, id)
+}
+
+func (f *FakeOVSDB) AddCanceledCallBack(id string, callback func()) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.canceledCallbacks[id] = callback
+}
+
+func (f *FakeOVSDB) AddCloseCallback(id string, callback func()) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.closeCallbacks[id] = callback
+}
+
+func (f *FakeOVSDB) RemoveCloseCallback(id string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.closeCallbacks, id)
+}
+
+func (f *FakeOVSDB) GetCounter() uint64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.counter++
+	return f.counter
+}
+
+// MonitorIds returns sorted ids of monitors with callbacks.
+func (f *FakeOVSDB) MonitorIds() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ids := make([]string, 0, len(f.callbacks))
+	for id := range f.callbacks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (f *FakeOVSDB) Monitor(schema string) *dbmonitor.Monitor {
+	return &dbmonitor.Monitor{
+		OVSDB:           f,
+		Schema:          schema,
+		MonitorRequests: make(map[string]interface{}),
+	}
+}
+
+// Transaction returns transaction sent to fake, like client Transaction.
+func (f *FakeOVSDB) Transaction(schema string) *dbtransaction.Transaction {
+	return &dbtransaction.Transaction{
+		OVSDB:      f,
+		Schema:     schema,
+		Tables:     map[string]string{},
+		References: make(map[string][]interface{}),
+		Counter:    1,
+	}
+}
+
+// Update sends update notification to all monitors.
+func (f *FakeOVSDB) Update(update string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, callback := range f.callbacks {
+		callback(json.RawMessage(update))
+	}
+}
+
+// NotifyMonitor sends update notification to monitor with id.
+func (f *FakeOVSDB) NotifyMonitor(id string, update string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if callback, ok := f.callbacks[id]; ok {
+		callback(json.RawMessage(update))
+	}
+}
+
+// Cancel sends monitor_canceled notification like server.
+func (f *FakeOVSDB) Cancel(id string) {
+	f.mutex.Lock()
+	canceled := f.canceledCallbacks[id]
+	delete(f.callbacks, id)
+	delete(f.canceledCallbacks, id)
+	f.mutex.Unlock()
+	if canceled != nil {
+		canceled()
+	}
+}
+
+// Close drops monitors like closed connection.
+func (f *FakeOVSDB) Close() {
+	f.mutex.Lock()
+	f.callbacks = make(map[string]dbmonitor.Callback)
+	closeCallbacks := make([]func(), 0, len(f.closeCallbacks))
+	for _, callback := range f.closeCallbacks {
+		closeCallbacks = append(closeCallbacks, callback)
+	}
+	f.mutex.Unlock()
+	for _, callback := range closeCallbacks {
+		callback()
+	}
+}
+
+// NewCache starts cache of Open_vSwitch and Bridge tables with Bridge name
+// index.
+func NewCache(t *testing.T, f *FakeOVSDB) *dbcache.Cache {
+	cache := &dbcache.Cache{
+		OVSDB:   f,
+		Schema:  "Open_vSwitch",
+		Indexes: map[string][]string{"Bridge": {"name"}},
+	}
+	err := cache.StartMonitor("Open_vSwitch", map[string][]string{
+		"Open_vSwitch": nil,
+		"Bridge":       nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
@@ -1,6 +1,7 @@
 package ovsdb
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -8,9 +9,11 @@ import (
 	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbcache"
 	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbmonitor"
 	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbtransaction"
+	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/ovshelper"
 	"io/ioutil"
 	"math/rand"
 	"net"
+	"os"
 	"strconv"
 	"sync"
 	"time"
@@ -40,7 +43,9 @@ type OVSDB struct {
 	pending map[uint64]*Pending
 	pendingMutex *sync.Mutex
 	callbacks map[string]dbmonitor.Callback
-	callbacksMutex *sync.Mutex
+	callbacksMutex sync.Mutex // usable without Dial, so callbacks can be registered on new(OVSDB)
+	closeCallbacks map[string]func()
+	canceledCallbacks map[string]func()
 	lockedCallback func(string)
 	stolenCallback func(string)
 	counter uint64
@@ -48,6 +53,8 @@ type OVSDB struct {
 	synchronize *Synchronize
 	closed bool
 	closedMutex *sync.Mutex
+	schemas map[string]*ovshelper.Schema
+	schemasMutex *sync.Mutex
 }
 
 // helper structure for synchronizing db connection and socket reads and writes
@@ -151,7 +158,8 @@ func Dial(addressList [][]string, initialize func(*OVSDB) error, options map[str
 	ovsdb.pending = make(map[uint64]*Pending)
 
 	ovsdb.callbacks = make(map[string]dbmonitor.Callback)
-	ovsdb.callbacksMutex = new(sync.Mutex)
+	ovsdb.closeCallbacks = make(map[string]func())
+	ovsdb.canceledCallbacks = make(map[string]func())
 
 	ovsdb.counterMutex = new(sync.Mutex)
 	ovsdb.counter = 0
@@ -161,6 +169,9 @@ func Dial(addressList [][]string, initialize func(*OVSDB) error, options map[str
 
 	ovsdb.closedMutex = new(sync.Mutex)
 
+	ovsdb.schemasMutex = new(sync.Mutex)
+	ovsdb.schemas = make(map[string]*ovshelper.Schema)
+
 	idx := 0
 	timeOut := 1
 
@@ -215,6 +226,11 @@ func Dial(addressList [][]string, initialize func(*OVSDB) error, options map[str
 
 				ovsdb.Conn = conn
 
+				// schema could be upgraded while we were disconnected
+				ovsdb.schemasMutex.Lock()
+				ovsdb.schemas = make(map[string]*ovshelper.Schema)
+				ovsdb.schemasMutex.Unlock()
+
 				ovsdb.decoderMutex.Lock()
 				ovsdb.dec = json.NewDecoder(conn)
 				ovsdb.decoderMutex.Unlock()
@@ -269,8 +285,20 @@ func (ovsdb *OVSDB) Close() error {
 	for id, _ := range ovsdb.callbacks {
 		delete(ovsdb.callbacks, id)
 	}
+	for id := range ovsdb.canceledCallbacks {
+		delete(ovsdb.canceledCallbacks, id)
+	}
+	closeCallbacks := make([]func(), 0, len(ovsdb.closeCallbacks))
+	for _, callback := range ovsdb.closeCallbacks {
+		closeCallbacks = append(closeCallbacks, callback)
+	}
 	ovsdb.callbacksMutex.Unlock()
 
+	// monitors are gone with connection, let their users know
+	for _, callback := range closeCallbacks {
+		callback()
+	}
+
 	// unlock all pending calls
 	ovsdb.pendingMutex.Lock()
 	for _, val := range ovsdb.pending {
@@ -344,14 +372,38 @@ func (ovsdb *OVSDB) loop() {
 				"id":     "echo",
 			}
 			ovsdb.encodeWrapper(resp)
-		case "update": // handle incoming update notification
+		case "update", "update2": // handle incoming update notification
 			var id string
 			json.Unmarshal(*msg.Params[0], &id)
 			ovsdb.callbacksMutex.Lock()
-			if _, ok := ovsdb.callbacks[id]; ok {
-				ovsdb.callbacks[id](*msg.Params[1])
+			callback := ovsdb.callbacks[id]
+			ovsdb.callbacksMutex.Unlock()
+			// callbacks may lock their own state, which is locked while
+			// callbacks are registered, so they are called unlocked
+			if callback != nil {
+				callback(*msg.Params[1])
 			}
+		case "update3": // monitor_cond_since notification, callback gets [last-txn-id, updates]
+			var id string
+			json.Unmarshal(*msg.Params[0], &id)
+			params, _ := json.Marshal(msg.Params[1:])
+			ovsdb.callbacksMutex.Lock()
+			callback := ovsdb.callbacks[id]
 			ovsdb.callbacksMutex.Unlock()
+			if callback != nil {
+				callback(params)
+			}
+		case "monitor_canceled": // server canceled monitor, for example because database was removed
+			var id string
+			json.Unmarshal(*msg.Params[0], &id)
+			ovsdb.callbacksMutex.Lock()
+			canceled := ovsdb.canceledCallbacks[id]
+			delete(ovsdb.callbacks, id)
+			delete(ovsdb.canceledCallbacks, id)
+			ovsdb.callbacksMutex.Unlock()
+			if canceled != nil {
+				canceled()
+			}
 		case "locked":
 			if ovsdb.lockedCallback != nil {
 				var resp string
@@ -455,10 +507,51 @@ func (ovsdb *OVSDB) Notify(method string, args interface{}) error {
 
 func (ovsdb *OVSDB) AddCallBack(id string, callback dbmonitor.Callback) {
 	ovsdb.callbacksMutex.Lock()
+	if ovsdb.callbacks == nil {
+		ovsdb.callbacks = make(map[string]dbmonitor.Callback)
+	}
 	ovsdb.callbacks[id] = callback
 	ovsdb.callbacksMutex.Unlock()
 }
 
+// RemoveCallBack unregisters monitor callbacks, notifications for monitor
+// are ignored afterwards.
+func (ovsdb *OVSDB) RemoveCallBack(id string) {
+	ovsdb.callbacksMutex.Lock()
+	delete(ovsdb.callbacks, id)
+	delete(ovsdb.canceledCallbacks, id)
+	ovsdb.callbacksMutex.Unlock()
+}
+
+// AddCanceledCallBack registers callback called when server cancels monitor
+// with id by monitor_canceled notification.
+func (ovsdb *OVSDB) AddCanceledCallBack(id string, callback func()) {
+	ovsdb.callbacksMutex.Lock()
+	if ovsdb.canceledCallbacks == nil {
+		ovsdb.canceledCallbacks = make(map[string]func())
+	}
+	ovsdb.canceledCallbacks[id] = callback
+	ovsdb.callbacksMutex.Unlock()
+}
+
+// AddCloseCallback registers callback called each time connection is closed.
+// Callback with the same id replaces previous one.
+func (ovsdb *OVSDB) AddCloseCallback(id string, callback func()) {
+	ovsdb.callbacksMutex.Lock()
+	if ovsdb.closeCallbacks == nil {
+		ovsdb.closeCallbacks = make(map[string]func())
+	}
+	ovsdb.closeCallbacks[id] = callback
+	ovsdb.callbacksMutex.Unlock()
+}
+
+// RemoveCloseCallback unregisters close callback.
+func (ovsdb *OVSDB) RemoveCloseCallback(id string) {
+	ovsdb.callbacksMutex.Lock()
+	delete(ovsdb.closeCallbacks, id)
+	ovsdb.callbacksMutex.Unlock()
+}
+
 func (ovsdb *OVSDB) GetCounter() uint64 {
 	ovsdb.counterMutex.Lock()
 	counter := ovsdb.counter
@@ -480,6 +573,31 @@ func (ovsdb *OVSDB) GetSchema(schema string) (json.RawMessage, error) {
 	return ovsdb.Call("get_schema", []string{schema}, nil)
 }
 
+// GetParsedSchema returns parsed schema. Schema is fetched once per connection.
+func (ovsdb *OVSDB) GetParsedSchema(schema string) (*ovshelper.Schema, error) {
+	ovsdb.schemasMutex.Lock()
+	parsed, ok := ovsdb.schemas[schema]
+	ovsdb.schemasMutex.Unlock()
+	if ok {
+		return parsed, nil
+	}
+
+	response, err := ovsdb.GetSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	parsed = new(ovshelper.Schema)
+	if err := json.Unmarshal(response, parsed); err != nil {
+		return nil, err
+	}
+
+	ovsdb.schemasMutex.Lock()
+	ovsdb.schemas[schema] = parsed
+	ovsdb.schemasMutex.Unlock()
+
+	return parsed, nil
+}
+
 // ===================================
 // ADVANCED FUNCTIONALITY CONSTRUCTORS
 // ===================================
@@ -497,6 +615,13 @@ func (ovsdb *OVSDB) Transaction(schema string) *dbtransaction.Transaction {
 	return txn
 }
 
+// RunTransaction builds transaction from consistent cache snapshot, guards
+// rows read from it and retries on conflicts until context expires.
+// See dbtransaction.RunTransaction.
+func (ovsdb *OVSDB) RunTransaction(ctx context.Context, cache *dbcache.Cache, fn func(*dbtransaction.Transaction, dbtransaction.CacheView) error) (dbtransaction.Transact, error) {
+	return dbtransaction.RunTransaction(ctx, ovsdb, cache, fn)
+}
+
 func (ovsdb *OVSDB) Monitor(schema string) *dbmonitor.Monitor {
 	monitor := new(dbmonitor.Monitor)
 
@@ -510,25 +635,83 @@ func (ovsdb *OVSDB) Monitor(schema string) *dbmonitor.Monitor {
 type Cache struct {
 	Schema string
 	Tables map[string][]string
+	Config map[string]dbcache.TableConfig // used instead of Tables to select rows and changes
 	Indexes map[string][]string
+	Method string // monitor method, see dbcache.Cache
+	File string // cache saved with Save is loaded from file if it exists, see dbcache.Cache.Load
 }
 
 func (ovsdb *OVSDB) Cache(c Cache) (*dbcache.Cache, error) {
+	cache, err := ovsdb.newCache(c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ovsdb.startCache(cache, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return cache, nil
+}
+
+// MultiCache makes one cache of several databases, one Cache for each
+// database. Caches are started one by one, if one fails, caches started
+// before it are stopped.
+func (ovsdb *OVSDB) MultiCache(caches ...Cache) (*dbcache.MultiCache, error) {
+	multi := dbcache.NewMultiCache()
+	var started []*dbcache.Cache
+	stop := func() {
+		for _, cache := range started {
+			cache.Stop()
+		}
+	}
+	for _, c := range caches {
+		cache, err := ovsdb.newCache(c)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		if err := multi.Add(cache); err != nil {
+			stop()
+			return nil, err
+		}
+		// failed start may leave monitor running
+		started = append(started, cache)
+		if err := ovsdb.startCache(cache, c); err != nil {
+			stop()
+			return nil, err
+		}
+	}
+
+	return multi, nil
+}
+
+func (ovsdb *OVSDB) newCache(c Cache) (*dbcache.Cache, error) {
 	cache := new(dbcache.Cache)
 
 	cache.ID = "id" + strconv.FormatUint(rand.Uint64(), 10)
 	cache.OVSDB = ovsdb
 	cache.Schema = c.Schema
 	cache.Indexes = c.Indexes
+	cache.Method = c.Method
 
-	err := cache.StartMonitor(c.Schema, c.Tables)
-	if err != nil {
-		return nil, err
+	if c.File != "" {
+		if err := cache.Load(c.File); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
 	}
 
 	return cache, nil
 }
 
+func (ovsdb *OVSDB) startCache(cache *dbcache.Cache, c Cache) error {
+	if c.Config != nil {
+		return cache.Start(c.Schema, c.Config)
+	}
+	return cache.StartMonitor(c.Schema, c.Tables)
+}
+
 // =======
 // LOCKING
 // =======
@@ -1,6 +1,7 @@
 package ovsdb
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbcache"
 	"github.com/TomCodeLV/OVSDB-golang-lib/pkg/dbmonitor"
@@ -710,3 +711,284 @@ func TestOVSDB_Persistent_Connection(t *testing.T) {
 
 	to.Stop()
 }
+
+func TestConditionBuilder(t *testing.T) {
+	where := dbtransaction.Where(
+		dbtransaction.HasUUID("2f0a3b4c-5d6e-4f70-8192-a3b4c5d6e7f8"),
+		dbtransaction.Equal("name", "br0"),
+		dbtransaction.Includes("external_ids", ovshelper.Map{"iface-id": "vm1"}),
+		dbtransaction.Excludes("ports", ovshelper.Set{ovshelper.UUID("c1b2a3d4-e5f6-4a7b-8c9d-0e1f2a3b4c5d")}),
+		dbtransaction.GreaterThan("ofport", 10),
+	)
+
+	encoded, _ := json.Marshal(where)
+	expected := `[["_uuid","==",["uuid","2f0a3b4c-5d6e-4f70-8192-a3b4c5d6e7f8"]],` +
+		`["name","==","br0"],` +
+		`["external_ids","includes",["map",[["iface-id","vm1"]]]],` +
+		`["ports","excludes",["set",[["uuid","c1b2a3d4-e5f6-4a7b-8c9d-0e1f2a3b4c5d"]]]],` +
+		`["ofport","\u003e",10]]`
+	if string(encoded) != expected {
+		t.Error("Wrong condition encoding: " + string(encoded))
+	}
+
+	// invalid conditions must fail before anything is sent
+	db := new(OVSDB)
+	txn := db.Transaction("Open_vSwitch")
+	txn.Select(dbtransaction.Select{
+		Table: "Bridge",
+		Where: [][]interface{}{{"name", "=", "br0"}},
+	})
+	if _, err, _ := txn.Commit(); err == nil {
+		t.Error("Unknown condition function accepted")
+	}
+
+	txn = db.Transaction("Open_vSwitch")
+	txn.Delete(dbtransaction.Delete{
+		Table: "Bridge",
+		Where: dbtransaction.Where(dbtransaction.LessThan("name", "br0")),
+	})
+	if _, err, _ := txn.Commit(); err == nil {
+		t.Error("Ordering condition on string accepted")
+	}
+}
+
+func TestOVSDB_Monitor_Conditional(t *testing.T) {
+	db := Dial([][]string{{network, address}}, nil, nil)
+	defer db.Close()
+
+	monitor := db.Monitor("Open_vSwitch")
+	monitor.Register("Bridge", dbmonitor.Table{
+		Columns: []string{"name"},
+		Where:   dbtransaction.Where(dbtransaction.Equal("name", "NO_SUCH_BRIDGE")),
+		Select:  dbmonitor.Select{Initial: true},
+	})
+	response, err := monitor.StartConditional(func(response json.RawMessage) {})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var update map[string]map[string]interface{}
+	json.Unmarshal(response, &update)
+	if len(update["Bridge"]) != 0 {
+		t.Error("Monitor condition ignored")
+	}
+}
+
+func TestMutationBuilder(t *testing.T) {
+	mutations := dbtransaction.Mutations(
+		dbtransaction.Add("next_cfg", 1),
+		dbtransaction.InsertToMap("external_ids", ovshelper.Map{"owner": "test"}),
+		dbtransaction.DeleteKeys("other_config", "stp-enable"),
+		dbtransaction.DeleteUUIDs("ports", "c1b2a3d4-e5f6-4a7b-8c9d-0e1f2a3b4c5d"),
+	)
+
+	encoded, _ := json.Marshal(mutations)
+	expected := `[["next_cfg","+=",1],` +
+		`["external_ids","insert",["map",[["owner","test"]]]],` +
+		`["other_config","delete",["set",["stp-enable"]]],` +
+		`["ports","delete",["set",[["uuid","c1b2a3d4-e5f6-4a7b-8c9d-0e1f2a3b4c5d"]]]]]`
+	if string(encoded) != expected {
+		t.Error("Wrong mutation encoding: " + string(encoded))
+	}
+
+	db := new(OVSDB)
+	invalid := []dbtransaction.Mutation{
+		dbtransaction.Add("name", "suffix"),
+		dbtransaction.Modulo("next_cfg", 1.5),
+		dbtransaction.Divide("next_cfg", 0),
+		{"next_cfg", "++", 1},
+	}
+	for _, mutation := range invalid {
+		txn := db.Transaction("Open_vSwitch")
+		txn.Mutate(dbtransaction.Mutate{
+			Table:     "Open_vSwitch",
+			Mutations: dbtransaction.Mutations(mutation),
+		})
+		if _, err, _ := txn.Commit(); err == nil {
+			t.Errorf("Invalid mutation accepted: %v", mutation)
+		}
+	}
+}
+
+func TestOVSDB_Transaction_Operations(t *testing.T) {
+	db := Dial([][]string{{network, address}}, nil, nil)
+	defer db.Close()
+
+	txn := db.Transaction("Open_vSwitch")
+	txn.Comment("test: rollback")
+	txn.Insert(dbtransaction.Insert{
+		Table: "Bridge",
+		Row: ovshelper.Bridge{
+			Name: "TEST_ABORTED_BRIDGE",
+		},
+	})
+	txn.Abort()
+	res, err, retry := txn.Commit()
+	if err == nil || retry || res != nil {
+		t.Error("Abort failed")
+		return
+	}
+	res = txn.Results
+	if len(res) < 3 || res[0].Op != "comment" || res[2].Op != "abort" || res[2].Error != "aborted" {
+		t.Error("Wrong abort results")
+	}
+
+	txn2 := db.Transaction("Open_vSwitch")
+	txn2.Assert("TEST_NOT_HELD_LOCK")
+	txn2.CommitDurable(true)
+	_, err2, _ := txn2.Commit()
+	if err2 == nil {
+		t.Error("Assert without lock succeeded")
+	}
+
+	db.Lock("TEST_LOCK")
+	txn3 := db.Transaction("Open_vSwitch")
+	txn3.Assert("TEST_LOCK")
+	txn3.Mutate(dbtransaction.Mutate{
+		Table:     "Open_vSwitch",
+		Mutations: dbtransaction.Mutations(dbtransaction.Add("next_cfg", 1)),
+	})
+	txn3.CommitDurable(true)
+	res3, err3, _ := txn3.Commit()
+	if err3 != nil {
+		t.Error(err3)
+		return
+	}
+	if res3[1].Op != "mutate" || res3[1].Count != 1 || res3[2].Op != "commit" {
+		t.Error("Wrong commit results")
+	}
+	db.Unlock("TEST_LOCK")
+}
+
+func TestInsertUUIDValidation(t *testing.T) {
+	db := new(OVSDB)
+
+	txn := db.Transaction("Open_vSwitch")
+	txn.Insert(dbtransaction.Insert{
+		Table: "Bridge",
+		Row:   ovshelper.Bridge{Name: "TEST_BRIDGE"},
+		UUID:  "not-a-uuid",
+	})
+	if _, err, _ := txn.Commit(); err == nil {
+		t.Error("Malformed uuid accepted")
+	}
+
+	txn = db.Transaction("Open_vSwitch")
+	for _, name := range []string{"TEST_BRIDGE", "TEST_BRIDGE2"} {
+		txn.Insert(dbtransaction.Insert{
+			Table: "Bridge",
+			Row:   ovshelper.Bridge{Name: name},
+			UUID:  "2f0a3b4c-5d6e-4f70-8192-a3b4c5d6e7f8",
+		})
+	}
+	if _, err, _ := txn.Commit(); err == nil {
+		t.Error("Reused uuid accepted")
+	}
+}
+
+func TestOVSDB_Insert_UUID(t *testing.T) {
+	db := Dial([][]string{{network, address}}, nil, nil)
+	defer db.Close()
+
+	uuid := "7c5e8a1e-3b2d-4f6a-9c0b-1d2e3f4a5b6c"
+
+	// abort keeps database clean, insert result is still reported
+	txn := db.Transaction("Open_vSwitch")
+	txn.Insert(dbtransaction.Insert{
+		Table: "Bridge",
+		Row:   ovshelper.Bridge{Name: "TEST_UUID_BRIDGE"},
+		UUID:  uuid,
+	})
+	txn.Abort()
+	txn.Commit()
+	res := txn.Results
+	if len(res) == 0 || len(res[0].UUID) != 2 || res[0].UUID[1] != uuid {
+		t.Error("Insert uuid ignored:", res)
+	}
+}
+
+func TestOVSDB_RunTransaction(t *testing.T) {
+	db := Dial([][]string{{network, address}}, nil, nil)
+	defer db.Close()
+
+	cache, err := db.Cache(Cache{
+		Schema: "Open_vSwitch",
+		Tables: map[string][]string{
+			"Open_vSwitch": nil,
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err = db.RunTransaction(ctx, cache, func(txn *dbtransaction.Transaction, view dbtransaction.CacheView) error {
+		schemaId := view.GetKeys("Open_vSwitch", "uuid")[0]
+		nextCfg := view.GetMap("Open_vSwitch", "uuid", schemaId)["next_cfg"].(float64)
+		txn.Update(dbtransaction.Update{
+			Table: "Open_vSwitch",
+			Where: dbtransaction.Where(dbtransaction.HasUUID(schemaId)),
+			Row:   map[string]interface{}{"next_cfg": nextCfg + 1},
+		})
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOVSDB_RowRef(t *testing.T) {
+	db := Dial([][]string{{network, address}}, nil, nil)
+	defer db.Close()
+
+	res, _ := db.Call("transact", []interface{}{"Open_vSwitch", map[string]interface{}{
+		"op":      "select",
+		"table":   "Open_vSwitch",
+		"where":   []interface{}{},
+		"columns": []string{"_uuid"},
+	}}, nil)
+	var rows []struct {
+		Rows []struct {
+			UUID []string `json:"_uuid"`
+		} `json:"rows"`
+	}
+	json.Unmarshal(res, &rows)
+	rootId := rows[0].Rows[0].UUID[1]
+
+	txn := db.Transaction("Open_vSwitch")
+	bridge := txn.Ref("Open_vSwitch", rootId).
+		AddChild(dbtransaction.Child{Table: "Bridge", Row: ovshelper.Bridge{Name: "TEST_ROW_REF"}})
+	bridge.AddChild(dbtransaction.Child{Table: "Port", Row: map[string]interface{}{"name": "TEST_ROW_REF"}}).
+		AddChild(dbtransaction.Child{Table: "Interface", Row: ovshelper.Interface{Name: "TEST_ROW_REF"}})
+	res2, err, _ := txn.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txn = db.Transaction("Open_vSwitch")
+	txn.Ref("Open_vSwitch", rootId).
+		RemoveChild(dbtransaction.Child{Table: "Bridge", UUID: res2[0].UUID[1]})
+	if _, err, _ := txn.Commit(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCallbacks_ZeroValue(t *testing.T) {
+	db := new(OVSDB)
+	db.AddCallBack("m1", func(response json.RawMessage) {})
+	db.AddCanceledCallBack("m1", func() {})
+	db.AddCloseCallback("c1", func() {})
+	if len(db.callbacks) != 1 || len(db.canceledCallbacks) != 1 || len(db.closeCallbacks) != 1 {
+		t.Error("Callbacks not registered")
+	}
+
+	db.RemoveCallBack("m1")
+	db.RemoveCloseCallback("c1")
+	if len(db.callbacks) != 0 || len(db.canceledCallbacks) != 0 || len(db.closeCallbacks) != 0 {
+		t.Error("Callbacks not removed")
+	}
+}
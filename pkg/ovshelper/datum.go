@@ -0,0 +1,661 @@
+package ovshelper
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// UUID is a row reference. It is encoded as ["uuid", "<id>"].
+type UUID string
+
+func (u UUID) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]string{"uuid", string(u)})
+}
+
+var uuidRegexp = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
+
+// IsUUID tells whether s has the form of uuid.
+func IsUUID(s string) bool {
+	return uuidRegexp.MatchString(s)
+}
+
+// NamedUUID refers to a row inserted earlier in the same transaction. It is
+// encoded as ["named-uuid", "<name>"].
+type NamedUUID string
+
+func (u NamedUUID) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]string{"named-uuid", string(u)})
+}
+
+// Set is an OVSDB set of atoms. It is encoded as ["set", [...]].
+type Set []interface{}
+
+func (s Set) MarshalJSON() ([]byte, error) {
+	atoms := s
+	if atoms == nil {
+		atoms = Set{}
+	}
+	return json.Marshal([]interface{}{"set", []interface{}(atoms)})
+}
+
+// Map is an OVSDB map of atom pairs. It is encoded as ["map", [[k, v], ...]]
+// with pairs sorted by key, so equal maps always produce equal JSON.
+type Map map[interface{}]interface{}
+
+func (m Map) MarshalJSON() ([]byte, error) {
+	keys := make([]interface{}, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+
+	pairs := make([]interface{}, len(keys))
+	for i, key := range keys {
+		pairs[i] = []interface{}{key, m[key]}
+	}
+	return json.Marshal([]interface{}{"map", pairs})
+}
+
+// ParseDatum converts value decoded from OVSDB JSON notation to UUID,
+// NamedUUID, Set, Map or atom (string, int64, float64 or bool). Numbers
+// should be decoded with json.Decoder.UseNumber to keep integers exact.
+func ParseDatum(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		if len(v) != 2 {
+			return nil, errors.New(fmt.Sprintf("invalid datum: %v", v))
+		}
+		switch v[0] {
+		case "set":
+			elements, ok := v[1].([]interface{})
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("invalid set: %v", v))
+			}
+			set := make(Set, len(elements))
+			for i, element := range elements {
+				atom, err := parseAtom(element)
+				if err != nil {
+					return nil, err
+				}
+				set[i] = atom
+			}
+			return set, nil
+		case "map":
+			pairs, ok := v[1].([]interface{})
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("invalid map: %v", v))
+			}
+			m := make(Map, len(pairs))
+			for _, p := range pairs {
+				pair, ok := p.([]interface{})
+				if !ok || len(pair) != 2 {
+					return nil, errors.New(fmt.Sprintf("invalid map pair: %v", p))
+				}
+				key, err := parseAtom(pair[0])
+				if err != nil {
+					return nil, err
+				}
+				val, err := parseAtom(pair[1])
+				if err != nil {
+					return nil, err
+				}
+				m[key] = val
+			}
+			return m, nil
+		}
+	}
+	return parseAtom(value)
+}
+
+func parseAtom(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string, bool, int64, float64, UUID, NamedUUID:
+		return v, nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case []interface{}:
+		if len(v) == 2 {
+			if id, ok := v[1].(string); ok {
+				switch v[0] {
+				case "uuid":
+					return UUID(id), nil
+				case "named-uuid":
+					return NamedUUID(id), nil
+				}
+			}
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("invalid atom: %v", value))
+}
+
+// ToDatum converts any value with OVSDB JSON encoding, for example values
+// built with this package or with helpers, to the form used by ParseDatum.
+func ToDatum(value interface{}) (interface{}, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	dec := json.NewDecoder(bytes.NewReader(encoded))
+	dec.UseNumber()
+	if err := dec.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return ParseDatum(decoded)
+}
+
+// atomKey makes numerically equal atoms equal map keys. Integral reals are
+// converted to int64 rather than integers to float64, so large integers stay
+// distinct.
+func atomKey(atom interface{}) interface{} {
+	switch v := atom.(type) {
+	case float64:
+		if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+			return int64(v)
+		}
+	}
+	return atom
+}
+
+func isNumber(atom interface{}) bool {
+	switch atom.(type) {
+	case int64, float64:
+		return true
+	}
+	return false
+}
+
+func toFloat(atom interface{}) float64 {
+	switch v := atom.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// asSet returns set elements, a single atom is treated as one element set.
+func asSet(datum interface{}) (Set, bool) {
+	switch v := datum.(type) {
+	case Set:
+		return v, true
+	case Map:
+		return nil, false
+	}
+	return Set{datum}, true
+}
+
+// asMap returns map, an empty set is treated as empty map.
+func asMap(datum interface{}) (Map, bool) {
+	switch v := datum.(type) {
+	case Map:
+		return v, true
+	case Set:
+		if len(v) == 0 {
+			return Map{}, true
+		}
+	}
+	return nil, false
+}
+
+func keyedSet(set Set) map[interface{}]bool {
+	keys := make(map[interface{}]bool, len(set))
+	for _, atom := range set {
+		keys[atomKey(atom)] = true
+	}
+	return keys
+}
+
+func keyedMap(m Map) map[interface{}]interface{} {
+	keys := make(map[interface{}]interface{}, len(m))
+	for key, val := range m {
+		keys[atomKey(key)] = atomKey(val)
+	}
+	return keys
+}
+
+// DatumEqual compares datums by value. A single atom equals one element set
+// with the same atom and an empty set equals an empty map.
+func DatumEqual(a interface{}, b interface{}) bool {
+	if am, ok := a.(Map); ok {
+		bm, ok := asMap(b)
+		return ok && mapIncludes(am, bm) && mapIncludes(bm, am)
+	}
+	if bm, ok := b.(Map); ok {
+		am, ok := asMap(a)
+		return ok && mapIncludes(am, bm) && mapIncludes(bm, am)
+	}
+	as, _ := asSet(a)
+	bs, _ := asSet(b)
+	ak, bk := keyedSet(as), keyedSet(bs)
+	if len(ak) != len(bk) {
+		return false
+	}
+	for key := range ak {
+		if !bk[key] {
+			return false
+		}
+	}
+	return true
+}
+
+func mapIncludes(m Map, pairs Map) bool {
+	keys := keyedMap(m)
+	for key, val := range pairs {
+		if v, ok := keys[atomKey(key)]; !ok || v != atomKey(val) {
+			return false
+		}
+	}
+	return true
+}
+
+func mapExcludes(m Map, pairs Map) bool {
+	keys := keyedMap(m)
+	for key, val := range pairs {
+		if v, ok := keys[atomKey(key)]; ok && v == atomKey(val) {
+			return false
+		}
+	}
+	return true
+}
+
+// EvalCondition evaluates RFC 7047 condition function for column value.
+func EvalCondition(function string, column interface{}, value interface{}) (bool, error) {
+	switch function {
+	case "==":
+		return DatumEqual(column, value), nil
+	case "!=":
+		return !DatumEqual(column, value), nil
+	case "includes", "excludes":
+		if cm, ok := column.(Map); ok {
+			vm, ok := asMap(value)
+			if !ok {
+				return false, errors.New(fmt.Sprintf("%s on map requires map value: %v", function, value))
+			}
+			if function == "includes" {
+				return mapIncludes(cm, vm), nil
+			}
+			return mapExcludes(cm, vm), nil
+		}
+		cs, _ := asSet(column)
+		vs, ok := asSet(value)
+		if !ok {
+			return false, errors.New(fmt.Sprintf("%s on set requires set value: %v", function, value))
+		}
+		keys := keyedSet(cs)
+		for _, atom := range vs {
+			if keys[atomKey(atom)] != (function == "includes") {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "<", "<=", ">", ">=":
+		// optional values are sets with zero or one element
+		if set, ok := column.(Set); ok {
+			if len(set) != 1 {
+				return false, nil
+			}
+			column = set[0]
+		}
+		if !isNumber(column) || !isNumber(value) {
+			return false, errors.New(fmt.Sprintf("%s requires integer or real values: %v %v", function, column, value))
+		}
+		c, v := toFloat(column), toFloat(value)
+		switch function {
+		case "<":
+			return c < v, nil
+		case "<=":
+			return c <= v, nil
+		case ">":
+			return c > v, nil
+		default:
+			return c >= v, nil
+		}
+	}
+	return false, errors.New("unknown condition function: " + function)
+}
+
+// ApplyMutation returns column value after RFC 7047 mutation.
+func ApplyMutation(column interface{}, mutator string, value interface{}) (interface{}, error) {
+	switch mutator {
+	case "+=", "-=", "*=", "/=", "%=":
+		if set, ok := column.(Set); ok {
+			ret := make(Set, len(set))
+			for i, atom := range set {
+				res, err := applyArithmetic(atom, mutator, value)
+				if err != nil {
+					return nil, err
+				}
+				ret[i] = res
+			}
+			return ret, nil
+		}
+		return applyArithmetic(column, mutator, value)
+	case "insert", "delete":
+		if cm, ok := asMap(column); ok {
+			if vm, ok := value.(Map); ok {
+				ret := Map{}
+				keys := keyedMap(cm)
+				for key, val := range cm {
+					if mutator == "delete" && mapIncludes(vm, Map{key: val}) {
+						continue
+					}
+					ret[key] = val
+				}
+				if mutator == "insert" {
+					for key, val := range vm {
+						if _, ok := keys[atomKey(key)]; !ok {
+							ret[key] = val
+						}
+					}
+				}
+				return ret, nil
+			}
+			if _, isMap := column.(Map); isMap {
+				if mutator == "insert" {
+					return nil, errors.New(fmt.Sprintf("insert into map requires map value: %v", value))
+				}
+				// delete by keys
+				vs, _ := asSet(value)
+				remove := keyedSet(vs)
+				ret := Map{}
+				for key, val := range cm {
+					if !remove[atomKey(key)] {
+						ret[key] = val
+					}
+				}
+				return ret, nil
+			}
+		}
+		cs, ok := asSet(column)
+		vs, ok2 := asSet(value)
+		if !ok || !ok2 {
+			return nil, errors.New(fmt.Sprintf("%s on set requires set value: %v", mutator, value))
+		}
+		ret := Set{}
+		if mutator == "insert" {
+			keys := keyedSet(cs)
+			ret = append(ret, cs...)
+			for _, atom := range vs {
+				if !keys[atomKey(atom)] {
+					keys[atomKey(atom)] = true
+					ret = append(ret, atom)
+				}
+			}
+		} else {
+			remove := keyedSet(vs)
+			for _, atom := range cs {
+				if !remove[atomKey(atom)] {
+					ret = append(ret, atom)
+				}
+			}
+		}
+		return ret, nil
+	}
+	return nil, errors.New("unknown mutator: " + mutator)
+}
+
+func applyArithmetic(atom interface{}, mutator string, value interface{}) (interface{}, error) {
+	if !isNumber(atom) || !isNumber(value) {
+		return nil, errors.New(fmt.Sprintf("%s requires integer or real values: %v %v", mutator, atom, value))
+	}
+	a, aok := atom.(int64)
+	v, vok := value.(int64)
+	if aok && vok {
+		switch mutator {
+		case "+=":
+			return a + v, nil
+		case "-=":
+			return a - v, nil
+		case "*=":
+			return a * v, nil
+		}
+		if v == 0 {
+			return nil, errors.New("domain error: division by zero")
+		}
+		if mutator == "/=" {
+			return a / v, nil
+		}
+		return a % v, nil
+	}
+	if mutator == "%=" {
+		return nil, errors.New(fmt.Sprintf("%s requires integer values: %v %v", mutator, atom, value))
+	}
+	af, vf := toFloat(atom), toFloat(value)
+	switch mutator {
+	case "+=":
+		return af + vf, nil
+	case "-=":
+		return af - vf, nil
+	case "*=":
+		return af * vf, nil
+	}
+	if vf == 0 {
+		return nil, errors.New("domain error: division by zero")
+	}
+	return af / vf, nil
+}
+
+// DatumKey returns string which is the same for equal datums, so datums can
+// be used as Go map keys. A single atom and one element set have the same
+// key, as do empty set and empty map.
+func DatumKey(datum interface{}) string {
+	switch d := datum.(type) {
+	case Set:
+		if len(d) == 1 {
+			return DatumKey(d[0])
+		}
+		keys := make([]string, 0, len(d))
+		for atom := range keyedSet(d) {
+			keys = append(keys, DatumKey(atom))
+		}
+		sort.Strings(keys)
+		return "[" + strings.Join(keys, ",") + "]"
+	case Map:
+		if len(d) == 0 {
+			return "[]"
+		}
+		pairs := make([]string, 0, len(d))
+		for key, val := range d {
+			pairs = append(pairs, DatumKey(key)+"="+DatumKey(val))
+		}
+		sort.Strings(pairs)
+		return "{" + strings.Join(pairs, ",") + "}"
+	case UUID:
+		return "uuid:" + string(d)
+	case NamedUUID:
+		return "named-uuid:" + string(d)
+	case string:
+		return strconv.Quote(d)
+	case int64:
+		return strconv.FormatInt(d, 10)
+	case float64:
+		if i, ok := atomKey(d).(int64); ok {
+			return strconv.FormatInt(i, 10)
+		}
+		return strconv.FormatFloat(d, 'g', -1, 64)
+	}
+	return fmt.Sprint(datum)
+}
+
+// SortedKeys returns keys of m in sorted order, so maps can be iterated
+// deterministically.
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// DecodeDatum converts value in OVSDB JSON notation to datum of column type.
+// Sets, including optional values, are always Set and maps are always Map,
+// so empty and single element values keep their type. Atoms are int64,
+// float64, bool, string or UUID as declared in schema. Result encodes back
+// to the same OVSDB notation, so it can be used in transactions.
+func DecodeDatum(t Type, value interface{}) (interface{}, error) {
+	datum, err := ParseDatum(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.IsMap() {
+		m, ok := asMap(datum)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("expected map, got %v", value))
+		}
+		ret := make(Map, len(m))
+		for key, val := range m {
+			k, err := convertAtom(t.Key, key)
+			if err != nil {
+				return nil, err
+			}
+			v, err := convertAtom(t.Value, val)
+			if err != nil {
+				return nil, err
+			}
+			ret[k] = v
+		}
+		return ret, nil
+	}
+
+	set, ok := asSet(datum)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("expected set, got %v", value))
+	}
+	if t.IsSet() {
+		ret := make(Set, len(set))
+		for i, atom := range set {
+			if ret[i], err = convertAtom(t.Key, atom); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	}
+	if len(set) != 1 {
+		return nil, errors.New(fmt.Sprintf("expected single value, got %v", value))
+	}
+	return convertAtom(t.Key, set[0])
+}
+
+func convertAtom(base BaseType, atom interface{}) (interface{}, error) {
+	switch base.Type {
+	case "integer":
+		switch a := atom.(type) {
+		case int64:
+			return a, nil
+		case float64:
+			if a == float64(int64(a)) {
+				return int64(a), nil
+			}
+		}
+	case "real":
+		if isNumber(atom) {
+			return toFloat(atom), nil
+		}
+	case "boolean":
+		if a, ok := atom.(bool); ok {
+			return a, nil
+		}
+	case "string":
+		if a, ok := atom.(string); ok {
+			return a, nil
+		}
+	case "uuid":
+		switch a := atom.(type) {
+		case UUID:
+			return a, nil
+		case string:
+			return UUID(a), nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("expected %s, got %v", base.Type, atom))
+}
+
+// DefaultDatum returns default value of column type: empty set or map, or
+// zero atom.
+func DefaultDatum(t Type) interface{} {
+	if t.IsMap() {
+		return Map{}
+	}
+	if t.IsSet() {
+		return Set{}
+	}
+	switch t.Key.Type {
+	case "integer":
+		return int64(0)
+	case "real":
+		return float64(0)
+	case "boolean":
+		return false
+	case "uuid":
+		return UUID("00000000-0000-0000-0000-000000000000")
+	}
+	return ""
+}
+
+// ApplyDiff applies column diff from update2 notification to datum. Both are
+// datums of column type, see DecodeDatum. Diff of a set holds elements to add
+// or remove, diff of a map holds pairs to add, remove (same value) or change
+// (different value), other values are replaced.
+func ApplyDiff(t Type, datum interface{}, diff interface{}) (interface{}, error) {
+	if t.IsMap() {
+		m, ok := asMap(datum)
+		d, ok2 := asMap(diff)
+		if !ok || !ok2 {
+			return nil, errors.New(fmt.Sprintf("invalid map diff: %v %v", datum, diff))
+		}
+		ret := make(Map, len(m))
+		for key, val := range m {
+			ret[key] = val
+		}
+		for key, val := range d {
+			if old, ok := ret[key]; ok && DatumEqual(old, val) {
+				delete(ret, key)
+			} else {
+				ret[key] = val
+			}
+		}
+		return ret, nil
+	}
+
+	if t.IsSet() {
+		s, ok := asSet(datum)
+		d, ok2 := asSet(diff)
+		if !ok || !ok2 {
+			return nil, errors.New(fmt.Sprintf("invalid set diff: %v %v", datum, diff))
+		}
+		remove := keyedSet(d)
+		ret := Set{}
+		for _, atom := range s {
+			if remove[atomKey(atom)] {
+				delete(remove, atomKey(atom))
+			} else {
+				ret = append(ret, atom)
+			}
+		}
+		for _, atom := range d {
+			if remove[atomKey(atom)] {
+				ret = append(ret, atom)
+				delete(remove, atomKey(atom))
+			}
+		}
+		return ret, nil
+	}
+
+	return diff, nil
+}
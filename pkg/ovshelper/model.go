@@ -0,0 +1,174 @@
+package ovshelper
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// columnName returns column name for struct field. Column is taken from
+// `ovsdb` tag, or from `json` tag if there is none. Fields without tags are
+// not mapped.
+func columnName(field reflect.StructField) string {
+	tag, ok := field.Tag.Lookup("ovsdb")
+	if !ok {
+		tag = field.Tag.Get("json")
+	}
+	name := strings.Split(tag, ",")[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// DecodeRow fills model struct from row in OVSDB notation. Fields are
+// matched to columns by `ovsdb` or `json` tag, `ovsdb:"_uuid"` receives row
+// uuid. Columns missing in row leave fields unchanged.
+//
+// Optional values (sets with zero or one element) can be decoded to pointers
+// or plain fields, sets to slices and maps to Go maps. Row references are
+// decoded to strings, or to UUID in interface fields.
+func DecodeRow(uuid string, row map[string]interface{}, model interface{}) error {
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New(fmt.Sprintf("model must be pointer to struct, got %T", model))
+	}
+	v = v.Elem()
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		column := columnName(field)
+		if column == "" || field.PkgPath != "" {
+			continue
+		}
+
+		var datum interface{}
+		if column == "_uuid" {
+			datum = UUID(uuid)
+		} else {
+			raw, ok := row[column]
+			if !ok {
+				continue
+			}
+			var err error
+			datum, err = ParseDatum(raw)
+			if err != nil {
+				return errors.New(fmt.Sprintf("column %s: %s", column, err.Error()))
+			}
+		}
+
+		if err := assignDatum(v.Field(i), datum); err != nil {
+			return errors.New(fmt.Sprintf("column %s: %s", column, err.Error()))
+		}
+	}
+
+	return nil
+}
+
+func assignDatum(v reflect.Value, datum interface{}) error {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !reflect.TypeOf(datum).AssignableTo(v.Type()) {
+			return errors.New(fmt.Sprintf("can not decode %v to %s", datum, v.Type()))
+		}
+		v.Set(reflect.ValueOf(datum))
+		return nil
+	case reflect.Ptr:
+		if set, ok := datum.(Set); ok {
+			if len(set) == 0 {
+				v.Set(reflect.Zero(v.Type()))
+				return nil
+			}
+		}
+		elem := reflect.New(v.Type().Elem())
+		if err := assignDatum(elem.Elem(), datum); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.Slice:
+		set, ok := asSet(datum)
+		if !ok {
+			return errors.New(fmt.Sprintf("can not decode map to %s", v.Type()))
+		}
+		slice := reflect.MakeSlice(v.Type(), len(set), len(set))
+		for i, atom := range set {
+			if err := assignDatum(slice.Index(i), atom); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	case reflect.Map:
+		m, ok := asMap(datum)
+		if !ok {
+			return errors.New(fmt.Sprintf("can not decode %v to %s", datum, v.Type()))
+		}
+		ret := reflect.MakeMapWithSize(v.Type(), len(m))
+		for key, val := range m {
+			k := reflect.New(v.Type().Key()).Elem()
+			if err := assignDatum(k, key); err != nil {
+				return err
+			}
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := assignDatum(e, val); err != nil {
+				return err
+			}
+			ret.SetMapIndex(k, e)
+		}
+		v.Set(ret)
+		return nil
+	}
+
+	// optional atom
+	if set, ok := datum.(Set); ok {
+		switch len(set) {
+		case 0:
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		case 1:
+			datum = set[0]
+		default:
+			return errors.New(fmt.Sprintf("can not decode set of %d elements to %s", len(set), v.Type()))
+		}
+	}
+
+	switch a := datum.(type) {
+	case string:
+		if v.Kind() == reflect.String {
+			v.SetString(a)
+			return nil
+		}
+	case UUID:
+		if v.Kind() == reflect.String {
+			v.SetString(string(a))
+			return nil
+		}
+	case bool:
+		if v.Kind() == reflect.Bool {
+			v.SetBool(a)
+			return nil
+		}
+	case int64:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v.SetInt(a)
+			return nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if a >= 0 {
+				v.SetUint(uint64(a))
+				return nil
+			}
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(float64(a))
+			return nil
+		}
+	case float64:
+		if v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64 {
+			v.SetFloat(a)
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("can not decode %v to %s", datum, v.Type()))
+}
@@ -1,5 +1,10 @@
 package ovshelper
 
+import (
+	"encoding/json"
+	"sort"
+)
+
 type BaseType struct {
 	Type string
 	Enum []interface{}
@@ -13,6 +18,38 @@ type BaseType struct {
 	RefType string
 }
 
+// UnmarshalJSON accepts both short form ("string") and full form of base type.
+func (b *BaseType) UnmarshalJSON(data []byte) error {
+	var atomicType string
+	if err := json.Unmarshal(data, &atomicType); err == nil {
+		*b = BaseType{Type: atomicType}
+		return nil
+	}
+
+	type baseType BaseType
+	var full struct {
+		baseType
+		Enum interface{} `json:"enum"`
+	}
+	if err := json.Unmarshal(data, &full); err != nil {
+		return err
+	}
+	*b = BaseType(full.baseType)
+
+	// enum is a set, or a single atom
+	if list, ok := full.Enum.([]interface{}); ok && len(list) == 2 && list[0] == "set" {
+		b.Enum, _ = list[1].([]interface{})
+	} else if full.Enum != nil {
+		b.Enum = []interface{}{full.Enum}
+	}
+	return nil
+}
+
+// IsStrongRef tells whether values are strong references to other rows.
+func (b BaseType) IsStrongRef() bool {
+	return b.RefTable != "" && b.RefType != "weak"
+}
+
 type Type struct {
 	Key BaseType
 	Value BaseType
@@ -21,13 +58,80 @@ type Type struct {
 	MaxUnlimited string `json:"max"`
 }
 
+// UnmarshalJSON accepts both short form ("string") and full form of column
+// type. Max is set to -1 and MaxUnlimited to "unlimited" for unlimited sets.
+func (t *Type) UnmarshalJSON(data []byte) error {
+	var atomicType string
+	if err := json.Unmarshal(data, &atomicType); err == nil {
+		*t = Type{Key: BaseType{Type: atomicType}, Min: 1, Max: 1}
+		return nil
+	}
+
+	var full struct {
+		Key BaseType
+		Value *BaseType
+		Min *int
+		Max interface{}
+	}
+	if err := json.Unmarshal(data, &full); err != nil {
+		return err
+	}
+
+	*t = Type{Key: full.Key, Min: 1, Max: 1}
+	if full.Value != nil {
+		t.Value = *full.Value
+	}
+	if full.Min != nil {
+		t.Min = *full.Min
+	}
+	switch max := full.Max.(type) {
+	case float64:
+		t.Max = int(max)
+	case string:
+		t.Max = -1
+		t.MaxUnlimited = max
+	}
+	return nil
+}
+
+// IsMap tells whether column is a map.
+func (t Type) IsMap() bool {
+	return t.Value.Type != ""
+}
+
+// IsSet tells whether column is a set, including optional values.
+func (t Type) IsSet() bool {
+	return !t.IsMap() && (t.Min != 1 || t.Max != 1)
+}
+
 type Column struct {
-	AtomicType string `json:"type"`
+	AtomicType string `json:"-"` // set when type is given in short form
 	Type Type `json:"type"`
 	Ephemeral bool
 	Mutable bool
 }
 
+func (c *Column) UnmarshalJSON(data []byte) error {
+	var column struct {
+		Type json.RawMessage
+		Ephemeral bool
+		Mutable *bool
+	}
+	if err := json.Unmarshal(data, &column); err != nil {
+		return err
+	}
+
+	*c = Column{Ephemeral: column.Ephemeral, Mutable: true}
+	if err := json.Unmarshal(column.Type, &c.Type); err != nil {
+		return err
+	}
+	json.Unmarshal(column.Type, &c.AtomicType)
+	if column.Mutable != nil {
+		c.Mutable = *column.Mutable
+	}
+	return nil
+}
+
 type Table struct {
 	Columns map[string]Column
 	MaxRows int
@@ -40,4 +144,17 @@ type Schema struct {
 	Version string `json:"version"`
 	Cksum string `json:"cksum"`
 	Tables map[string]Table `json:"tables"`
-}
\ No newline at end of file
+}
+
+// References returns sorted names of table columns which refer to rows of
+// refTable.
+func (s *Schema) References(table string, refTable string) []string {
+	columns := []string{}
+	for name, column := range s.Tables[table].Columns {
+		if column.Type.Key.RefTable == refTable || column.Type.Value.RefTable == refTable {
+			columns = append(columns, name)
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}